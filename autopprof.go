@@ -5,9 +5,16 @@ package autopprof
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"os"
+	"sort"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/daangn/autopprof/v2/queryer"
@@ -17,10 +24,33 @@ import (
 type autoPprof struct {
 	watchInterval               time.Duration
 	minConsecutiveOverThreshold int
+	cooldown                    time.Duration
+
+	// globalCooldown spaces threshold-triggered reports across every
+	// runner; lastFireAt (guarded by fireMu) is when the last one fired.
+	globalCooldown time.Duration
+	fireMu         sync.Mutex
+	lastFireAt     time.Time
 
 	reporter      report.Reporter
 	reportTimeout time.Duration
 	app           string
+	labels        map[string]string
+
+	// commentTmpl and filenameTmpl replace the default comment and
+	// filename; nil keeps the defaults.
+	commentTmpl  *template.Template
+	filenameTmpl *template.Template
+
+	queryErrorPolicy QueryErrorPolicy
+	logger           Logger
+	hooks            Hooks
+	cascade          CascadePolicy
+
+	// queue is the async report queue; nil reports synchronously.
+	queue *reportQueue
+	// spool keeps undeliverable reports; nil when disabled.
+	spool *spool
 
 	disableCPUProf       bool
 	disableMemProf       bool
@@ -30,10 +60,12 @@ type autoPprof struct {
 	runtimeQueryer queryer.RuntimeQueryer
 	profiler       profiler
 
-	// cascadedRunners holds only the built-in metrics so cascadeBuiltIn
-	// can iterate them. Populated during Start and thereafter read-only —
-	// no mutex needed.
-	cascadedRunners map[string]*metricRunner
+	// mu guards runners and serializes registration against stop so
+	// wg.Add never races with wg.Wait.
+	mu sync.Mutex
+	// runners holds every live runner (built-in and custom) keyed by
+	// name. Unregister removes entries; built-ins stay until Stop.
+	runners map[string]*metricRunner
 
 	// wg tracks every live watcher goroutine so Stop blocks until
 	// in-flight pprof work (CPU profiling runs up to ~10s) unwinds.
@@ -42,45 +74,263 @@ type autoPprof struct {
 	stopC    chan struct{}
 }
 
-type metricRunner struct {
-	metric    Metric
-	name      string
-	threshold float64
-	interval  time.Duration
+// Profiler is an independent autopprof instance. Each Profiler owns
+// its watchers, so several differently configured instances can live
+// in one process, and a stopped Profiler can be started again.
+type Profiler struct {
+	opt Option
+
+	// mu guards ap, the running instance (nil while stopped).
+	mu sync.Mutex
+	ap *autoPprof
+}
+
+// New validates opt and returns a stopped Profiler. Call Start to
+// begin watching.
+func New(opt Option) (*Profiler, error) {
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
+	return &Profiler{opt: opt}, nil
+}
+
+// Start runs the watchers. It returns ErrAlreadyStarted if the
+// Profiler is already running.
+func (p *Profiler) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ap != nil {
+		return ErrAlreadyStarted
+	}
+	ap, err := start(p.opt)
+	if err != nil {
+		return err
+	}
+	p.ap = ap
+	return nil
+}
+
+// Stop stops every watcher and blocks until in-flight work unwinds.
+// Metrics added via Register are dropped; Option.Metrics are
+// registered again on the next Start. No-op if not running.
+func (p *Profiler) Stop() {
+	p.mu.Lock()
+	ap := p.ap
+	p.ap = nil
+	p.mu.Unlock()
+	if ap != nil {
+		ap.stop()
+	}
 }
 
-// globalAp is the running instance, or nil before Start. Access is
-// guarded by startOnce / stopOnce — Start and Stop each fire at most
-// once per process.
+// Register adds a user Metric to the running Profiler. The metric's
+// watcher runs until Unregister or Stop.
+func (p *Profiler) Register(m Metric) error {
+	ap := p.running()
+	if ap == nil {
+		return ErrNotStarted
+	}
+	return ap.registerMetric(m)
+}
+
+// Unregister stops the watcher of the user Metric registered under
+// name and blocks until its in-flight Query and Collect calls, and the
+// synchronous Reporter calls that follow them, finish; no Query or
+// Collect of the metric starts afterwards. Reports already handed to
+// the ReportQueue may still be delivered after it returns. It
+// returns ErrMetricNotFound for unknown names and ErrUnregisterBuiltIn
+// for the built-in cpu/mem/goroutine metrics. Must not be called from
+// the metric's own Query or Collect.
+func (p *Profiler) Unregister(name string) error {
+	ap := p.running()
+	if ap == nil {
+		return ErrNotStarted
+	}
+	return ap.unregisterMetric(name)
+}
+
+// Update replaces the live configuration of the metric registered
+// under name, built-in or custom, without restarting its watcher. The
+// new config applies atomically from the watcher's next tick. The
+// metric keeps the Direction it was registered with, and the cpu
+// metric its Option.WatchInterval, which CPUUsageWindow is sampled
+// at: Update rejects another one. A zero Threshold of a built-in
+// means its default. A metric whose watcher exhausted its
+// QueryErrorPolicy is watched again.
+func (p *Profiler) Update(name string, cfg MetricConfig) error {
+	ap := p.running()
+	if ap == nil {
+		return ErrNotStarted
+	}
+	return ap.updateMetric(name, cfg)
+}
+
+// Reconfigure applies the built-in thresholds, re-arm thresholds,
+// sustain, rate and anomaly conditions and Disable*Prof flags of opt
+// to the Profiler. Running built-ins are retuned in place (a
+// disabled one pauses); a built-in that was disabled at Start, or
+// whose watcher exhausted its QueryErrorPolicy, is started; cpu
+// stays off, as at Start, if the CPU quota isn't set. Other
+// fields of opt are ignored and need not be set. The applied fields
+// also carry over to the next Start, so every built-in may only be
+// disabled if the Profiler has Option.Metrics.
+func (p *Profiler) Reconfigure(opt Option) error {
+	if err := opt.validateBuiltins(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if opt.DisableCPUProf && opt.DisableMemProf && opt.DisableGoroutineProf && len(p.opt.Metrics) == 0 {
+		return ErrDisableAllProfiling
+	}
+	if p.ap != nil {
+		if err := p.ap.reconfigure(opt); err != nil {
+			return err
+		}
+	}
+	p.opt.CPUThreshold = opt.CPUThreshold
+	p.opt.MemThreshold = opt.MemThreshold
+	p.opt.GoroutineThreshold = opt.GoroutineThreshold
+	p.opt.CPUReArmThreshold = opt.CPUReArmThreshold
+	p.opt.MemReArmThreshold = opt.MemReArmThreshold
+	p.opt.GoroutineReArmThreshold = opt.GoroutineReArmThreshold
+	p.opt.CPUSustain = opt.CPUSustain
+	p.opt.MemSustain = opt.MemSustain
+	p.opt.GoroutineSustain = opt.GoroutineSustain
+	p.opt.MemRate = opt.MemRate
+	p.opt.GoroutineRate = opt.GoroutineRate
+	p.opt.CPUAnomaly = opt.CPUAnomaly
+	p.opt.MemAnomaly = opt.MemAnomaly
+	p.opt.GoroutineAnomaly = opt.GoroutineAnomaly
+	p.opt.DisableCPUProf = opt.DisableCPUProf
+	p.opt.DisableMemProf = opt.DisableMemProf
+	p.opt.DisableGoroutineProf = opt.DisableGoroutineProf
+	return nil
+}
+
+// Capture queries the named metric and reports it right away through
+// the same Collect/Reporter pipeline as a threshold breach, whether or
+// not the value is over threshold. CPU profiles still serialize with
+// the watchers. ctx bounds the Reporter call and is checked before
+// Collect and again before the report, but Collect itself, e.g. a 10s
+// CPU profile, is not canceled by it.
+func (p *Profiler) Capture(
+	ctx context.Context, name string,
+) (report.ReportInfo, error) {
+	ap := p.running()
+	if ap == nil {
+		return report.ReportInfo{}, ErrNotStarted
+	}
+	return ap.capture(ctx, name)
+}
+
+// CaptureAll captures every registered metric, built-in and custom,
+// in name order. It returns the ReportInfo of each successful capture
+// and an error aggregating the failed ones.
+func (p *Profiler) CaptureAll(ctx context.Context) ([]report.ReportInfo, error) {
+	ap := p.running()
+	if ap == nil {
+		return nil, ErrNotStarted
+	}
+	return ap.captureAll(ctx)
+}
+
+// FailedMetrics returns the metrics whose watcher exhausted its
+// QueryErrorPolicy budget and exited, keyed by name, with the last
+// Query error. Update, or Reconfigure for a built-in, starts a new
+// watcher for a failed metric; Unregister drops a failed custom one.
+func (p *Profiler) FailedMetrics() map[string]error {
+	ap := p.running()
+	if ap == nil {
+		return nil
+	}
+	return ap.failedMetrics()
+}
+
+// Status returns the configuration and live state of every registered
+// metric, built-in and custom, in name order.
+func (p *Profiler) Status() []MetricStatus {
+	ap := p.running()
+	if ap == nil {
+		return nil
+	}
+	return ap.status()
+}
+
+// ReplaySpool redelivers the spooled reports now, oldest first,
+// without waiting for the background redelivery. It stops at the
+// first failure and returns the number delivered. It returns
+// ErrSpoolDisabled when Option.Spool is unset.
+func (p *Profiler) ReplaySpool(ctx context.Context) (int, error) {
+	ap := p.running()
+	if ap == nil {
+		return 0, ErrNotStarted
+	}
+	return ap.replaySpool(ctx)
+}
+
+func (p *Profiler) running() *autoPprof {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ap
+}
+
+// globalProfiler backs the package-level functions, or nil before
+// Start. Access is guarded by startOnce / stopOnce — Start and Stop
+// each fire at most once per process.
 var (
-	globalAp  *autoPprof
-	startOnce sync.Once
-	startErr  error
-	stopOnce  sync.Once
+	globalProfiler *Profiler
+	startOnce      sync.Once
+	startErr       error
+	stopOnce       sync.Once
 )
 
-// Start configures and runs the autopprof process. It executes at
+// Start configures and runs the default Profiler. It executes at
 // most once per process — subsequent calls return the same error (or
 // nil) as the first invocation. Safe to call concurrently; later
-// callers block on the first one.
+// callers block on the first one. Use New for independent lifecycles.
 func Start(opt Option) error {
 	startOnce.Do(func() {
-		startErr = start(opt)
+		p, err := New(opt)
+		if err == nil {
+			err = p.Start()
+		}
+		if err != nil {
+			startErr = err
+			return
+		}
+		globalProfiler = p
 	})
 	return startErr
 }
 
-func start(opt Option) error {
-	cgroupQryer, err := queryer.NewCgroupQueryer()
+func start(opt Option) (*autoPprof, error) {
+	watchInterval := defaultWatchInterval
+	if opt.WatchInterval > 0 {
+		watchInterval = opt.WatchInterval
+	}
+	cpuProfilingDuration := defaultCPUProfilingDuration
+	if opt.CPUProfilingDuration > 0 {
+		cpuProfilingDuration = opt.CPUProfilingDuration
+	}
+	minConsecutiveOverThreshold := defaultMinConsecutiveOverThreshold
+	if opt.MinConsecutiveOverThreshold > 0 {
+		minConsecutiveOverThreshold = opt.MinConsecutiveOverThreshold
+	}
+	cpuUsageWindow := defaultCPUUsageWindow
+	if opt.CPUUsageWindow > 0 {
+		cpuUsageWindow = opt.CPUUsageWindow
+	}
+
+	cgroupQryer, err := queryer.NewCgroupQueryerWithWindow(
+		cpuUsageSnapshots(cpuUsageWindow, watchInterval),
+	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	runtimeQryer, err := queryer.NewRuntimeQueryer()
 	if err != nil {
-		return err
-	}
-	if err := opt.validate(); err != nil {
-		return err
+		return nil, err
 	}
 
 	app := opt.App
@@ -91,77 +341,215 @@ func start(opt Option) error {
 	if opt.ReportTimeout > 0 {
 		reportTimeout = opt.ReportTimeout
 	}
-	profr := newDefaultProfiler(defaultCPUProfilingDuration)
+	var logger Logger = stdLogger{}
+	if opt.Logger != nil {
+		logger = opt.Logger
+	}
+	commentTmpl, err := parseTemplate("CommentTemplate", opt.CommentTemplate)
+	if err != nil {
+		return nil, err
+	}
+	filenameTmpl, err := parseTemplate("FilenameTemplate", opt.FilenameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	profr := newDefaultProfiler(cpuProfilingDuration)
 	ap := &autoPprof{
-		watchInterval:               defaultWatchInterval,
-		minConsecutiveOverThreshold: defaultMinConsecutiveOverThreshold,
+		watchInterval:               watchInterval,
+		minConsecutiveOverThreshold: minConsecutiveOverThreshold,
+		cooldown:                    opt.Cooldown,
+		globalCooldown:              opt.GlobalCooldown,
 		reporter:                    opt.Reporter,
 		reportTimeout:               reportTimeout,
 		app:                         app,
+		labels:                      copyLabels(opt.Labels),
+		commentTmpl:                 commentTmpl,
+		filenameTmpl:                filenameTmpl,
+		queryErrorPolicy:            opt.QueryErrorPolicy.withDefaults(),
+		logger:                      logger,
+		hooks:                       opt.Hooks,
+		cascade:                     opt.Cascade,
 		disableCPUProf:              opt.DisableCPUProf,
 		disableMemProf:              opt.DisableMemProf,
 		disableGoroutineProf:        opt.DisableGoroutineProf,
 		cgroupQueryer:               cgroupQryer,
 		runtimeQueryer:              runtimeQryer,
 		profiler:                    profr,
-		cascadedRunners:             make(map[string]*metricRunner),
+		runners:                     make(map[string]*metricRunner),
 		stopC:                       make(chan struct{}),
 	}
 	if !ap.disableCPUProf {
 		if err := ap.loadCPUQuota(); err != nil {
-			return err
+			return nil, err
+		}
+	}
+	if opt.Spool.Dir != "" {
+		sp, err := newSpool(opt.Spool.withDefaults())
+		if err != nil {
+			return nil, err
 		}
+		ap.spool = sp
+		ap.wg.Add(1)
+		go ap.redeliverSpool()
 	}
+	if opt.ReportQueue.Size > 0 {
+		ap.queue = newReportQueue(ap, opt.ReportQueue.withDefaults())
+	}
+	opt.DisableCPUProf = ap.disableCPUProf
 	ap.registerBuiltinMetrics(opt)
 	for _, m := range opt.Metrics {
 		if err := ap.registerMetric(m); err != nil {
 			ap.stop()
-			return err
+			return nil, err
 		}
 	}
-	globalAp = ap
-	return nil
+	for _, rule := range opt.Rules {
+		if rule.Interval == 0 {
+			rule.Interval = watchInterval
+		}
+		ap.wg.Add(1)
+		go ap.watchRule(rule)
+	}
+	return ap, nil
 }
 
-// Stop stops the global autopprof process. It executes at most once
-// per process; subsequent calls are no-ops. Safe to call concurrently.
+// cpuUsageSnapshots is the number of cpu usage samples, taken every
+// interval, that span window.
+func cpuUsageSnapshots(window, interval time.Duration) int {
+	return int(window / interval)
+}
+
+// Stop stops the default Profiler. It executes at most once per
+// process; subsequent calls are no-ops. Safe to call concurrently.
 func Stop() {
 	stopOnce.Do(func() {
-		if globalAp == nil {
+		if globalProfiler == nil {
 			return
 		}
-		globalAp.stop()
-		globalAp = nil
+		globalProfiler.Stop()
+		globalProfiler = nil
 	})
 }
 
-// Register adds a user Metric to the running autopprof instance. The
-// metric's watcher runs until Stop.
+// Register adds a user Metric to the default Profiler. The metric's
+// watcher runs until Unregister or Stop.
 func Register(m Metric) error {
-	if globalAp == nil {
+	if globalProfiler == nil {
+		return ErrNotStarted
+	}
+	return globalProfiler.Register(m)
+}
+
+// Unregister removes a user Metric from the default Profiler. See
+// Profiler.Unregister.
+func Unregister(name string) error {
+	if globalProfiler == nil {
+		return ErrNotStarted
+	}
+	return globalProfiler.Unregister(name)
+}
+
+// Update retunes a metric of the default Profiler. See
+// Profiler.Update.
+func Update(name string, cfg MetricConfig) error {
+	if globalProfiler == nil {
 		return ErrNotStarted
 	}
-	return globalAp.registerMetric(m)
+	return globalProfiler.Update(name, cfg)
+}
+
+// Reconfigure retunes the built-ins of the default Profiler. See
+// Profiler.Reconfigure.
+func Reconfigure(opt Option) error {
+	if globalProfiler == nil {
+		return ErrNotStarted
+	}
+	return globalProfiler.Reconfigure(opt)
+}
+
+// FailedMetrics lists the dead watchers of the default Profiler. See
+// Profiler.FailedMetrics.
+func FailedMetrics() map[string]error {
+	if globalProfiler == nil {
+		return nil
+	}
+	return globalProfiler.FailedMetrics()
+}
+
+// Status describes every metric of the default Profiler. See
+// Profiler.Status.
+func Status() []MetricStatus {
+	if globalProfiler == nil {
+		return nil
+	}
+	return globalProfiler.Status()
+}
+
+// ReplaySpool redelivers the spooled reports of the default Profiler.
+// See Profiler.ReplaySpool.
+func ReplaySpool(ctx context.Context) (int, error) {
+	if globalProfiler == nil {
+		return 0, ErrNotStarted
+	}
+	return globalProfiler.ReplaySpool(ctx)
+}
+
+// Capture reports the named metric of the default Profiler on demand.
+// See Profiler.Capture.
+func Capture(ctx context.Context, name string) (report.ReportInfo, error) {
+	if globalProfiler == nil {
+		return report.ReportInfo{}, ErrNotStarted
+	}
+	return globalProfiler.Capture(ctx, name)
+}
+
+// CaptureAll reports every metric of the default Profiler on demand.
+// See Profiler.CaptureAll.
+func CaptureAll(ctx context.Context) ([]report.ReportInfo, error) {
+	if globalProfiler == nil {
+		return nil, ErrNotStarted
+	}
+	return globalProfiler.CaptureAll(ctx)
 }
 
 // loadCPUQuota resolves the container CPU limit. If the cgroup quota
 // isn't set we log and silently disable CPU profiling (matching v1).
 func (ap *autoPprof) loadCPUQuota() error {
-	err := ap.cgroupQueryer.SetCPUQuota()
+	ok, err := ap.setCPUQuota(ap.disableMemProf)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		ap.disableCPUProf = true
+	}
+	return nil
+}
+
+// setCPUQuota resolves the container CPU limit for the cpu built-in.
+// ok is false, with a warning logged, if the quota isn't set; err is
+// returned instead when mem profiling is disabled too.
+func (ap *autoPprof) setCPUQuota(memDisabled bool) (ok bool, err error) {
+	err = ap.cgroupQueryer.SetCPUQuota()
 	if err == nil {
-		return nil
+		return true, nil
 	}
-	if ap.disableMemProf {
-		return err
+	if memDisabled {
+		return false, err
 	}
-	log.Println(
+	ap.logger.Warn(
 		"autopprof: disable the cpu profiling due to the CPU quota isn't set",
+		logKeyMetric, MetricNameCPU, logKeyError, err,
 	)
-	ap.disableCPUProf = true
-	return nil
+	return false, nil
 }
 
-func (ap *autoPprof) registerBuiltinMetrics(opt Option) {
+// builtinSpec pairs a built-in Metric with its Disable*Prof flag.
+type builtinSpec struct {
+	metric   Metric
+	disabled bool
+}
+
+func (ap *autoPprof) builtinMetrics(opt Option) []builtinSpec {
 	cpuThreshold := defaultCPUThreshold
 	if opt.CPUThreshold != 0 {
 		cpuThreshold = opt.CPUThreshold
@@ -174,163 +562,1053 @@ func (ap *autoPprof) registerBuiltinMetrics(opt Option) {
 	if opt.GoroutineThreshold != 0 {
 		goroutineThreshold = opt.GoroutineThreshold
 	}
-
-	if !ap.disableCPUProf {
-		ap.registerBuiltIn(&cpuMetric{
-			app: ap.app, threshold: cpuThreshold,
-			cg: ap.cgroupQueryer, p: ap.profiler,
-		})
-	}
-	if !ap.disableMemProf {
-		ap.registerBuiltIn(&memMetric{
-			app: ap.app, threshold: memThreshold,
-			cg: ap.cgroupQueryer, p: ap.profiler,
-		})
+	return []builtinSpec{
+		{
+			metric: &cpuMetric{
+				app: ap.app, threshold: cpuThreshold, reArmThreshold: opt.CPUReArmThreshold,
+				sustain: opt.CPUSustain, anomaly: opt.CPUAnomaly,
+				cg: ap.cgroupQueryer, p: ap.profiler,
+			},
+			disabled: opt.DisableCPUProf,
+		},
+		{
+			metric: &memMetric{
+				app: ap.app, threshold: memThreshold, reArmThreshold: opt.MemReArmThreshold,
+				sustain: opt.MemSustain, rate: opt.MemRate, anomaly: opt.MemAnomaly,
+				cg: ap.cgroupQueryer, p: ap.profiler,
+			},
+			disabled: opt.DisableMemProf,
+		},
+		{
+			metric: &goroutineMetric{
+				app: ap.app, threshold: goroutineThreshold, reArmThreshold: opt.GoroutineReArmThreshold,
+				sustain: opt.GoroutineSustain, rate: opt.GoroutineRate, anomaly: opt.GoroutineAnomaly,
+				rt: ap.runtimeQueryer, p: ap.profiler,
+			},
+			disabled: opt.DisableGoroutineProf,
+		},
 	}
-	if !ap.disableGoroutineProf {
-		ap.registerBuiltIn(&goroutineMetric{
-			app: ap.app, threshold: goroutineThreshold,
-			rt: ap.runtimeQueryer, p: ap.profiler,
-		})
+}
+
+func (ap *autoPprof) registerBuiltinMetrics(opt Option) {
+	for _, b := range ap.builtinMetrics(opt) {
+		if !b.disabled {
+			ap.registerBuiltIn(b.metric)
+		}
 	}
 }
 
-func (ap *autoPprof) registerBuiltIn(m Metric) {
-	runner := newRunner(m, ap.watchInterval)
-	ap.cascadedRunners[runner.name] = runner
-	ap.wg.Add(1)
-	go func() {
-		defer ap.wg.Done()
-		ap.watchMetric(runner, true)
-	}()
+func (ap *autoPprof) registerBuiltIn(m Metric) error {
+	runner := newRunner(m, ap.resolveConfig(metricConfigOf(m)))
+	runner.builtin = true
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	select {
+	case <-ap.stopC:
+		return ErrNotStarted
+	default:
+	}
+	if _, ok := ap.runners[runner.name]; ok {
+		return ErrDuplicateMetric
+	}
+	ap.runners[runner.name] = runner
+	ap.spawn(runner)
+	return nil
 }
 
 func (ap *autoPprof) registerMetric(m Metric) error {
 	if err := validateMetric(m); err != nil {
 		return err
 	}
+	runner := newRunner(m, ap.resolveConfig(metricConfigOf(m)))
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
 	select {
 	case <-ap.stopC:
 		return ErrNotStarted
 	default:
 	}
-	runner := newRunner(m, ap.watchInterval)
+	if isBuiltInName(runner.name) {
+		return ErrReservedMetricName
+	}
+	if _, ok := ap.runners[runner.name]; ok {
+		return ErrDuplicateMetric
+	}
+	ap.runners[runner.name] = runner
+	ap.spawn(runner)
+	return nil
+}
+
+// spawn starts the runner's watcher. Callers must hold ap.mu.
+func (ap *autoPprof) spawn(runner *metricRunner) {
 	ap.wg.Add(1)
 	go func() {
 		defer ap.wg.Done()
-		ap.watchMetric(runner, false)
+		defer close(runner.doneC)
+		ap.watchMetric(runner)
+		callHook(ap.hooks.OnWatcherExit, Event{
+			MetricName: runner.name,
+			Threshold:  runner.config().Threshold,
+			Err:        runner.failed(),
+		})
 	}()
+}
+
+func (ap *autoPprof) unregisterMetric(name string) error {
+	if isBuiltInName(name) {
+		return ErrUnregisterBuiltIn
+	}
+	ap.mu.Lock()
+	runner, ok := ap.runners[name]
+	if ok {
+		delete(ap.runners, name)
+	}
+	ap.mu.Unlock()
+	if !ok {
+		return ErrMetricNotFound
+	}
+	close(runner.stopC)
+	<-runner.doneC
+	runner.remove()
+	return nil
+}
+
+func (ap *autoPprof) updateMetric(name string, cfg MetricConfig) error {
+	// A zero built-in threshold means the default, as in Option.
+	if cfg.Threshold == 0 {
+		switch name {
+		case MetricNameCPU:
+			cfg.Threshold = defaultCPUThreshold
+		case MetricNameMem:
+			cfg.Threshold = defaultMemThreshold
+		case MetricNameGoroutine:
+			cfg.Threshold = defaultGoroutineThreshold
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if isBuiltInName(name) && cfg.Direction != DirectionAbove {
+		return ErrInvalidMetricConfig
+	}
+	switch name {
+	case MetricNameCPU:
+		if cfg.Threshold > 1 {
+			return ErrInvalidCPUThreshold
+		}
+		// The cpu usage is averaged over a fixed number of samples, so
+		// another interval would stretch or shrink CPUUsageWindow.
+		if cfg.Interval != 0 && cfg.Interval != ap.watchInterval {
+			return ErrInvalidMetricConfig
+		}
+	case MetricNameMem:
+		if cfg.Threshold > 1 {
+			return ErrInvalidMemThreshold
+		}
+	}
+	ap.mu.Lock()
+	runner, ok := ap.runners[name]
+	ap.mu.Unlock()
+	if !ok {
+		return ErrMetricNotFound
+	}
+	// The zero Direction, DirectionAbove, keeps a below-threshold
+	// metric below.
+	direction := runner.config().Direction
+	if cfg.Direction != DirectionAbove && cfg.Direction != direction {
+		return ErrInvalidMetricConfig
+	}
+	cfg.Direction = direction
+	ap.updateRunner(runner, ap.resolveConfig(cfg))
+	return nil
+}
+
+// updateRunner applies cfg to runner. A runner whose watcher exhausted
+// its error budget is replaced by a new one, watched again with cfg,
+// that keeps its Status counters.
+func (ap *autoPprof) updateRunner(runner *metricRunner, cfg MetricConfig) {
+	if runner.failed() == nil {
+		runner.update(cfg)
+		return
+	}
+	fresh := newRunner(runner.metric, cfg)
+	fresh.builtin = runner.builtin
+	fresh.groups = runner.groups
+	fresh.state = runner.snapshotState()
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	select {
+	case <-ap.stopC:
+		runner.update(cfg)
+		return
+	default:
+	}
+	if ap.runners[runner.name] != runner {
+		// Unregistered or already replaced meanwhile.
+		return
+	}
+	ap.runners[runner.name] = fresh
+	ap.spawn(fresh)
+}
+
+// reconfigure retunes running built-ins from opt and starts the ones
+// that were disabled at Start but are enabled now.
+func (ap *autoPprof) reconfigure(opt Option) error {
+	for _, b := range ap.builtinMetrics(opt) {
+		ap.mu.Lock()
+		runner, ok := ap.runners[b.metric.Name()]
+		ap.mu.Unlock()
+		if ok {
+			cfg := runner.config()
+			cfg.Threshold = b.metric.Threshold()
+			builtinCfg := metricConfigOf(b.metric)
+			cfg.ReArmThreshold = builtinCfg.ReArmThreshold
+			cfg.Sustain = builtinCfg.Sustain
+			cfg.Rate = builtinCfg.Rate
+			cfg.Anomaly = builtinCfg.Anomaly
+			cfg.Disabled = b.disabled
+			ap.updateRunner(runner, cfg)
+			continue
+		}
+		if b.disabled {
+			continue
+		}
+		if b.metric.Name() == MetricNameCPU {
+			// Like Start, go on without cpu if the quota isn't set.
+			ok, err := ap.setCPUQuota(opt.DisableMemProf)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if err := ap.registerBuiltIn(b.metric); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// newRunner caches Metric's meta values so the watch loop uses a
-// stable name/threshold/interval even if the implementation mutates
-// them later.
-func newRunner(m Metric, globalInterval time.Duration) *metricRunner {
-	interval := m.Interval()
-	if interval == 0 {
-		interval = globalInterval
+// resolveConfig fills cfg's zero Interval, MinConsecutiveOverThreshold
+// and Cooldown with the instance defaults.
+func (ap *autoPprof) resolveConfig(cfg MetricConfig) MetricConfig {
+	if cfg.Interval == 0 {
+		cfg.Interval = ap.watchInterval
+	}
+	if cfg.MinConsecutiveOverThreshold == 0 {
+		cfg.MinConsecutiveOverThreshold = ap.minConsecutiveOverThreshold
 	}
-	return &metricRunner{
-		metric:    m,
-		name:      m.Name(),
-		threshold: m.Threshold(),
-		interval:  interval,
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = ap.cooldown
 	}
+	return cfg
 }
 
 // watchMetric runs the unified watch loop. minConsecutiveOverThreshold
 // debounces repeat fires: report on the first tick above threshold,
-// suppress until the counter drops below threshold or wraps around.
-func (ap *autoPprof) watchMetric(runner *metricRunner, isBuiltin bool) {
-	ticker := time.NewTicker(runner.interval)
+// suppress until the counter drops below the re-arm threshold or
+// wraps around. Values between the re-arm threshold and the threshold
+// neither fire nor re-arm (hysteresis). A Rate or Anomaly breach
+// counts as a breach whatever the level. A breach only fires once its
+// Sustain condition holds, and is suppressed while the per-metric or
+// global cooldown runs. The runner's config is snapshotted once per
+// tick.
+func (ap *autoPprof) watchMetric(runner *metricRunner) {
+	interval := runner.config().Interval
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	var cnt int
+	var (
+		cnt        int
+		lastFireAt time.Time
+		tracker    breachTracker
+		rates      rateTracker
+		anomalies  anomalyTracker
+	)
 	for {
 		select {
 		case <-ticker.C:
-			value, err := runner.metric.Query()
+			cfg := runner.config()
+			if cfg.Disabled {
+				cnt = 0
+				runner.setConsecutiveOver(cnt)
+				tracker.reset()
+				rates.reset()
+				anomalies.reset()
+				continue
+			}
+			value, err := ap.queryWithRetry(runner)
+			if errors.Is(err, errWatcherStopped) {
+				return
+			}
 			if err != nil {
-				log.Println(fmt.Errorf(
-					"autopprof: metric %q query failed: %w", runner.name, err,
-				))
+				ap.logger.Error("autopprof: metric query failed, watcher exits",
+					logKeyMetric, runner.name,
+					logKeyPhase, phaseQuery,
+					logKeyError, err,
+				)
+				runner.fail(err)
 				return
 			}
-			if value < runner.threshold {
+			change, changing := rates.observe(cfg.Rate, cfg.Direction, value, time.Now())
+			baseline, deviation, anomalous := anomalies.observe(cfg.Anomaly, cfg.Direction, value)
+			breached := cfg.breached(value) || changing || anomalous
+			sustained := tracker.observe(cfg.Sustain, breached, time.Now())
+			if !changing && !anomalous && cfg.rearmed(value) {
 				cnt = 0
+				runner.setConsecutiveOver(cnt)
 				continue
 			}
-			if cnt == 0 {
-				if err := ap.fireReport(runner, value); err != nil {
-					log.Println(fmt.Errorf(
-						"autopprof: metric %q report failed: %w", runner.name, err,
-					))
-				}
-				if isBuiltin {
-					ap.cascadeBuiltIn(runner.name)
+			if !breached {
+				// Hysteresis band: wait for the re-arm threshold.
+				continue
+			}
+			event := Event{
+				MetricName: runner.name,
+				Value:      value,
+				Threshold:  cfg.Threshold,
+			}
+			callHook(ap.hooks.OnBreach, event)
+			now := time.Now()
+			if cnt != 0 {
+				callHook(ap.hooks.OnDebounced, event)
+			} else if !sustained {
+				// Pending: not breaching for long enough yet.
+				continue
+			} else if (!lastFireAt.IsZero() && now.Sub(lastFireAt) < cfg.Cooldown) ||
+				!ap.takeGlobalCooldown(now) {
+				// Stay at cnt == 0 so the breach fires as soon as the
+				// cooldown is over.
+				callHook(ap.hooks.OnDebounced, event)
+				continue
+			} else {
+				lastFireAt = now
+				inc := newIncident(runner.name)
+				switch {
+				case cfg.breached(value):
+					inc.trigger = report.TriggerThreshold
+				case changing:
+					inc.trigger, inc.change = report.TriggerRate, change
+				default:
+					inc.trigger = report.TriggerAnomaly
+					inc.baseline, inc.deviation = baseline, deviation
 				}
+				ap.fire(runner, cfg, value, event, inc)
 			}
 			cnt++
-			if cnt >= ap.minConsecutiveOverThreshold {
+			if cnt >= cfg.MinConsecutiveOverThreshold {
 				cnt = 0
 			}
+			runner.setConsecutiveOver(cnt)
+		case <-runner.reconfC:
+			if cfg := runner.config(); cfg.Interval != interval {
+				interval = cfg.Interval
+				ticker.Reset(interval)
+			}
+		case <-runner.stopC:
+			return
 		case <-ap.stopC:
 			return
 		}
 	}
 }
 
-func (ap *autoPprof) fireReport(runner *metricRunner, value float64) error {
-	result, err := runner.metric.Collect(value)
+// fire reports a breach of runner as inc and runs its cascade,
+// bundled into one report when CascadePolicy.Bundle is set.
+func (ap *autoPprof) fire(runner *metricRunner, cfg MetricConfig, value float64, event Event, inc incident) {
+	targets, cascades := ap.cascadeTargets(runner)
+	if cascades && len(targets) > 0 && ap.cascade.Bundle != BundleNone {
+		callHook(ap.hooks.OnCascade, event)
+		ap.bundleFrom(runner, cfg, value, inc, targets)
+		return
+	}
+	if _, err := ap.dispatchReport(runner, cfg, value, inc); err != nil {
+		ap.logReportError(runner, cfg, value, err)
+	}
+	if cascades {
+		callHook(ap.hooks.OnCascade, event)
+		inc.cascade = true
+		ap.cascadeFrom(inc, targets)
+	}
+}
+
+// logReportError logs a failed report of a breach.
+func (ap *autoPprof) logReportError(runner *metricRunner, cfg MetricConfig, value float64, err error) {
+	ap.logger.Error("autopprof: metric report failed",
+		logKeyMetric, runner.name,
+		logKeyValue, value,
+		logKeyThreshold, cfg.Threshold,
+		logKeyPhase, phaseOf(err),
+		logKeyError, err,
+	)
+}
+
+// takeGlobalCooldown reports whether a threshold-triggered report may
+// fire at now under globalCooldown and, if so, starts a new cooldown.
+func (ap *autoPprof) takeGlobalCooldown(now time.Time) bool {
+	if ap.globalCooldown <= 0 {
+		return true
+	}
+	ap.fireMu.Lock()
+	defer ap.fireMu.Unlock()
+	if !ap.lastFireAt.IsZero() && now.Sub(ap.lastFireAt) < ap.globalCooldown {
+		return false
+	}
+	ap.lastFireAt = now
+	return true
+}
+
+// errWatcherStopped aborts a query retry when the runner is stopped
+// while backing off.
+var errWatcherStopped = errors.New("autopprof: watcher stopped")
+
+// queryWithRetry queries the runner, retrying failures with
+// exponential backoff until the consecutive-error budget of
+// queryErrorPolicy is exhausted.
+func (ap *autoPprof) queryWithRetry(runner *metricRunner) (float64, error) {
+	policy := ap.queryErrorPolicy
+	backoff := policy.InitialBackoff
+	for consecutive := 1; ; consecutive++ {
+		value, err := runner.query()
+		if err == nil {
+			return value, nil
+		}
+		if policy.OnQueryError != nil {
+			policy.OnQueryError(runner.name, err, consecutive)
+		}
+		callHook(ap.hooks.OnError, Event{
+			MetricName: runner.name, Phase: phaseQuery, Err: err,
+		})
+		if consecutive >= policy.MaxConsecutiveErrors {
+			return 0, err
+		}
+		ap.logger.Warn("autopprof: metric query failed, retrying",
+			logKeyMetric, runner.name,
+			logKeyPhase, phaseQuery,
+			"consecutive", consecutive,
+			"max_consecutive", policy.MaxConsecutiveErrors,
+			"backoff", backoff,
+			logKeyError, err,
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-runner.stopC:
+			timer.Stop()
+			return 0, errWatcherStopped
+		case <-ap.stopC:
+			timer.Stop()
+			return 0, errWatcherStopped
+		}
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// incident ties a breach to the reports of its cascade.
+type incident struct {
+	id          string
+	triggeredBy string
+	cascade     bool
+	// trigger is a report.Trigger* constant, empty for on-demand
+	// captures. change is the Rate change of a TriggerRate breach;
+	// baseline and deviation describe a TriggerAnomaly one, condition
+	// a TriggerRule one.
+	trigger   string
+	change    float64
+	baseline  float64
+	deviation float64
+	condition string
+}
+
+// newIncident starts an incident triggered by the named metric, empty
+// for on-demand captures.
+func newIncident(triggeredBy string) incident {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Unique enough within a process.
+		binary.BigEndian.PutUint64(b[:], uint64(time.Now().UnixNano()))
+	}
+	return incident{id: hex.EncodeToString(b[:]), triggeredBy: triggeredBy}
+}
+
+// fireReport collects the runner's payload and ships it. ctx bounds
+// the Reporter call on top of reportTimeout. The returned ReportInfo
+// carries only MetricName/Value/Threshold and the incident when
+// Collect returned no Reader.
+func (ap *autoPprof) fireReport(
+	ctx context.Context, runner *metricRunner, cfg MetricConfig, value float64, inc incident,
+) (report.ReportInfo, error) {
+	job, err := ap.collectJob(runner, cfg, value, inc)
+	if err != nil || job.reader == nil {
+		return job.info, err
+	}
+	// Collect can't be canceled; don't ship what ctx gave up on.
+	if err := ctx.Err(); err != nil {
+		return job.info, err
+	}
+	return job.info, ap.deliver(ctx, job)
+}
+
+// dispatchReport is fireReport for the watchers and the cascade: with
+// a report queue, the collected payload is queued instead of shipped.
+func (ap *autoPprof) dispatchReport(
+	runner *metricRunner, cfg MetricConfig, value float64, inc incident,
+) (report.ReportInfo, error) {
+	if ap.queue == nil {
+		return ap.fireReport(context.Background(), runner, cfg, value, inc)
+	}
+	job, err := ap.collectJob(runner, cfg, value, inc)
+	if err != nil || job.reader == nil {
+		return job.info, err
+	}
+	ap.queue.enqueue(job)
+	return job.info, nil
+}
+
+// reportJob is a collected payload waiting for Reporter.Report.
+type reportJob struct {
+	runner *metricRunner
+	// members are the other runners whose payloads a bundle carries.
+	members []*metricRunner
+	reader  io.Reader
+	info    report.ReportInfo
+	event   Event
+}
+
+// collectJob runs the runner's Collect and fills in the ReportInfo
+// defaults. job.reader is nil when Collect returned no Reader.
+func (ap *autoPprof) collectJob(
+	runner *metricRunner, cfg MetricConfig, value float64, inc incident,
+) (reportJob, error) {
+	job := reportJob{
+		runner: runner,
+		info: report.ReportInfo{
+			MetricName:  runner.name,
+			Value:       value,
+			Threshold:   cfg.Threshold,
+			Direction:   cfg.Direction.String(),
+			IncidentID:  inc.id,
+			TriggeredBy: inc.triggeredBy,
+			IsCascade:   inc.cascade,
+			Trigger:     inc.trigger,
+			Condition:   inc.condition,
+		},
+		event: Event{
+			MetricName: runner.name,
+			Value:      value,
+			Threshold:  cfg.Threshold,
+		},
+	}
+	if !inc.cascade {
+		job.info.Change = inc.change
+		job.info.Baseline, job.info.Deviation = inc.baseline, inc.deviation
+	}
+	event := &job.event
+	callHook(ap.hooks.OnCollectStart, *event)
+	begin := time.Now()
+	result, err := runner.collect(value)
+	end := time.Now()
+	event.Duration = end.Sub(begin)
+	event.Size = payloadSize(result.Reader)
 	if err != nil {
-		return fmt.Errorf("collect: %w", err)
+		runner.recordError(err)
+		event.Phase, event.Err = phaseCollect, err
+		callHook(ap.hooks.OnCollectEnd, *event)
+		callHook(ap.hooks.OnError, *event)
+		return job, &phaseError{phase: phaseCollect, err: err}
 	}
 	if result.Reader == nil {
 		// Side-effect-only hook; nothing to ship.
-		return nil
+		callHook(ap.hooks.OnCollectEnd, *event)
+		return job, nil
 	}
 
-	info := report.ReportInfo{
-		MetricName: runner.name,
-		Filename:   result.Filename,
-		Comment:    result.Comment,
-		Value:      value,
-		Threshold:  runner.threshold,
-	}
+	info := &job.info
+	info.Filename = result.Filename
+	info.Comment = result.Comment
+	ap.describeCapture(info, begin, end)
+	ap.applyTemplates(runner, info, result)
 	if info.Filename == "" {
 		info.Filename = defaultFilename(runner.name)
 	}
 	if info.Comment == "" {
-		info.Comment = defaultComment(runner.name, value, runner.threshold)
+		info.Comment = reportComment(runner, cfg, *info)
+	}
+
+	job.reader = result.Reader
+	event.Info = *info
+	callHook(ap.hooks.OnCollectEnd, *event)
+	return job, nil
+}
+
+// reportComment is the default comment of a report: why the metric
+// fired for the breaching report of a rate or anomaly incident and the
+// reports of a rule, its level against the threshold otherwise.
+func reportComment(runner *metricRunner, cfg MetricConfig, info report.ReportInfo) string {
+	if !info.IsCascade {
+		switch info.Trigger {
+		case report.TriggerRate:
+			if c, ok := runner.metric.(rateCommenter); ok {
+				return c.rateComment(info.Value, info.Change, cfg.Rate)
+			}
+			return defaultRateComment(runner.name, info.Value, info.Change, cfg.Rate)
+		case report.TriggerAnomaly:
+			if c, ok := runner.metric.(anomalyCommenter); ok {
+				return c.anomalyComment(info.Value, info.Baseline, info.Deviation)
+			}
+			return defaultAnomalyComment(runner.name, info.Value, info.Baseline, info.Deviation)
+		case report.TriggerRule:
+			return fmt.Sprintf(":rotating_light:[%s] value=%.2f, rule %s: %s",
+				runner.name, info.Value, info.TriggeredBy, info.Condition)
+		}
+	}
+	if c, ok := runner.metric.(commenter); ok {
+		return c.comment(info.Value, cfg.Threshold)
+	}
+	return defaultComment(runner.name, info.Value, cfg.Threshold, cfg.Direction)
+}
+
+// describeCapture fills the capture and process fields of info.
+func (ap *autoPprof) describeCapture(info *report.ReportInfo, begin, end time.Time) {
+	build := currentBuildInfo()
+	info.StartTime = begin
+	info.EndTime = end
+	info.Hostname = hostnameSafe()
+	info.PID = os.Getpid()
+	info.App = ap.app
+	info.Labels = copyLabels(ap.labels)
+	info.GoVersion = build.GoVersion
+	info.BuildVersion = build.Version
+	info.BuildRevision = build.Revision
+}
+
+// applyTemplates fills info's Filename and Comment from the
+// templates: always for the built-ins' filenames, otherwise only where
+// Collect left them empty. A failing template leaves the default and
+// is logged.
+func (ap *autoPprof) applyTemplates(
+	runner *metricRunner, info *report.ReportInfo, result CollectResult,
+) {
+	if ap.commentTmpl == nil && ap.filenameTmpl == nil {
+		return
+	}
+	data := TemplateData{
+		MetricName:  info.MetricName,
+		Value:       info.Value,
+		Threshold:   info.Threshold,
+		Direction:   info.Direction,
+		Trigger:     info.Trigger,
+		Condition:   info.Condition,
+		Change:      info.Change,
+		Baseline:    info.Baseline,
+		Deviation:   info.Deviation,
+		BuiltIn:     runner.builtin,
+		App:         info.App,
+		Hostname:    info.Hostname,
+		Time:        info.EndTime,
+		Labels:      info.Labels,
+		Build:       currentBuildInfo(),
+		IncidentID:  info.IncidentID,
+		TriggeredBy: info.TriggeredBy,
+		IsCascade:   info.IsCascade,
 	}
+	apply := func(t *template.Template, field *string) {
+		s, err := executeTemplate(t, data)
+		if err != nil {
+			ap.logger.Warn("autopprof: template failed, using the default",
+				logKeyMetric, info.MetricName,
+				"template", t.Name(),
+				logKeyError, err,
+			)
+			return
+		}
+		*field = s
+	}
+	if ap.filenameTmpl != nil && (runner.builtin || result.Filename == "") {
+		apply(ap.filenameTmpl, &info.Filename)
+	}
+	if ap.commentTmpl != nil && result.Comment == "" {
+		apply(ap.commentTmpl, &info.Comment)
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ap.reportTimeout)
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+// deliver ships a collected payload through the Reporter. With a
+// spool, a payload the Reporter fails to take is spooled for
+// redelivery.
+func (ap *autoPprof) deliver(ctx context.Context, job reportJob) error {
+	var (
+		payload io.ReadSeeker
+		start   int64
+	)
+	if ap.spool != nil {
+		var err error
+		if payload, start, err = rewindable(job.reader); err != nil {
+			return &phaseError{phase: phaseReport, err: err}
+		}
+		job.reader = payload
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ap.reportTimeout)
 	defer cancel()
-	return ap.reporter.Report(ctx, result.Reader, info)
+	event := job.event
+	begin := time.Now()
+	err := ap.reporter.Report(ctx, job.reader, job.info)
+	event.Duration = time.Since(begin)
+	job.runner.recordReport(err)
+	for _, m := range job.members {
+		m.recordReport(err)
+	}
+	event.Phase, event.Err = phaseReport, err
+	callHook(ap.hooks.OnReport, event)
+	if err != nil {
+		callHook(ap.hooks.OnError, event)
+		if payload != nil {
+			ap.spoolPayload(payload, start, job.info)
+		}
+		return &phaseError{phase: phaseReport, err: err}
+	}
+	return nil
 }
 
-// cascadeBuiltIn reports the other enabled built-in metrics whenever
-// any built-in breaches. Custom metrics stay independent.
-// cascadedRunners is read-only after Start, so no lock.
-func (ap *autoPprof) cascadeBuiltIn(triggered string) {
-	for name, r := range ap.cascadedRunners {
-		if name == triggered {
-			continue
+// spoolPayload saves an undelivered payload, read from start.
+func (ap *autoPprof) spoolPayload(payload io.ReadSeeker, start int64, info report.ReportInfo) {
+	_, err := payload.Seek(start, io.SeekStart)
+	var b []byte
+	if err == nil {
+		b, err = io.ReadAll(payload)
+	}
+	if err == nil {
+		err = ap.spool.save(b, info)
+	}
+	if err != nil {
+		ap.logger.Error("autopprof: failed to spool report",
+			logKeyMetric, info.MetricName,
+			logKeyPhase, phaseReport,
+			logKeyError, err,
+		)
+		return
+	}
+	ap.logger.Info("autopprof: report spooled for redelivery",
+		logKeyMetric, info.MetricName,
+		"filename", info.Filename,
+	)
+}
+
+// replaySpool redelivers the spooled reports, oldest first.
+func (ap *autoPprof) replaySpool(ctx context.Context) (int, error) {
+	if ap.spool == nil {
+		return 0, ErrSpoolDisabled
+	}
+	return ap.spool.replay(ctx, func(ctx context.Context, r io.Reader, info report.ReportInfo) error {
+		ctx, cancel := context.WithTimeout(ctx, ap.reportTimeout)
+		defer cancel()
+		return ap.reporter.Report(ctx, r, info)
+	})
+}
+
+// redeliverSpool replays the spool every RetryInterval until Stop.
+func (ap *autoPprof) redeliverSpool() {
+	defer ap.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-ap.stopC:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(ap.spool.opt.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := ap.replaySpool(ctx)
+			if n > 0 {
+				ap.logger.Info("autopprof: spooled reports redelivered", "count", n)
+			}
+			if err != nil && ctx.Err() == nil {
+				ap.logger.Warn("autopprof: spool redelivery failed",
+					logKeyPhase, phaseReport,
+					logKeyError, err,
+				)
+			}
+		case <-ap.stopC:
+			return
 		}
-		value, err := r.metric.Query()
+	}
+}
+
+// capture queries the named metric and reports it immediately,
+// regardless of threshold, debounce or the Disabled flag.
+func (ap *autoPprof) capture(
+	ctx context.Context, name string,
+) (report.ReportInfo, error) {
+	ap.mu.Lock()
+	runner, ok := ap.runners[name]
+	ap.mu.Unlock()
+	if !ok {
+		return report.ReportInfo{}, ErrMetricNotFound
+	}
+	return ap.captureRunner(ctx, runner, newIncident(""))
+}
+
+// captureAll captures every registered metric in name order. Failures
+// don't stop the sweep; they come back together as one error.
+func (ap *autoPprof) captureAll(
+	ctx context.Context,
+) ([]report.ReportInfo, error) {
+	runners := ap.sortedRunners()
+	var (
+		infos = make([]report.ReportInfo, 0, len(runners))
+		errs  multiError
+		inc   = newIncident("")
+	)
+	for _, r := range runners {
+		info, err := ap.captureRunner(ctx, r, inc)
 		if err != nil {
-			log.Println(fmt.Errorf(
-				"autopprof: cascade query %q: %w", r.name, err,
+			errs = append(errs, fmt.Errorf(
+				"autopprof: capture %q: %w", r.name, err,
 			))
 			continue
 		}
-		if err := ap.fireReport(r, value); err != nil {
-			log.Println(fmt.Errorf(
-				"autopprof: cascade report %q: %w", r.name, err,
-			))
+		infos = append(infos, info)
+	}
+	if len(errs) > 0 {
+		return infos, errs
+	}
+	return infos, nil
+}
+
+func (ap *autoPprof) captureRunner(
+	ctx context.Context, runner *metricRunner, inc incident,
+) (report.ReportInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return report.ReportInfo{MetricName: runner.name}, err
+	}
+	// Unregister waits for the capture, or the capture skips the
+	// runner it removed.
+	if !runner.acquire() {
+		return report.ReportInfo{MetricName: runner.name}, ErrMetricNotFound
+	}
+	defer runner.release()
+	cfg := runner.config()
+	value, err := runner.query()
+	if err != nil {
+		callHook(ap.hooks.OnError, Event{
+			MetricName: runner.name, Threshold: cfg.Threshold,
+			Phase: phaseQuery, Err: err,
+		})
+		return report.ReportInfo{MetricName: runner.name},
+			&phaseError{phase: phaseQuery, err: err}
+	}
+	return ap.fireReport(ctx, runner, cfg, value, inc)
+}
+
+// cascadeTargets returns, in name order, the enabled runners that a
+// fire of triggered cascades to: the other members of every cascade
+// group it triggers. ok is false if it triggers no group.
+func (ap *autoPprof) cascadeTargets(triggered *metricRunner) (targets []*metricRunner, ok bool) {
+	if ap.cascade.Disabled {
+		return nil, false
+	}
+	runners := ap.sortedRunners()
+	members := ap.cascadeMembers(runners)
+	triggers := make(map[string][]string)
+	for _, g := range ap.cascade.groups() {
+		triggers[g.Name] = g.Triggers
+	}
+
+	names := make(map[string]bool)
+	for group, groupMembers := range members {
+		fires := groupMembers[triggered.name]
+		if len(triggers[group]) > 0 {
+			fires = containsString(triggers[group], triggered.name)
+		}
+		if !fires {
+			continue
+		}
+		ok = true
+		for name := range groupMembers {
+			names[name] = true
 		}
 	}
+	delete(names, triggered.name)
+
+	for _, r := range runners {
+		if names[r.name] && !r.config().Disabled {
+			targets = append(targets, r)
+		}
+	}
+	return targets, ok
+}
+
+// cascadeMembers maps every cascade group to its member names: the
+// declared Members plus the runners that opted in via CascadeMetric.
+func (ap *autoPprof) cascadeMembers(runners []*metricRunner) map[string]map[string]bool {
+	members := make(map[string]map[string]bool)
+	add := func(group, name string) {
+		if members[group] == nil {
+			members[group] = make(map[string]bool)
+		}
+		members[group][name] = true
+	}
+	for _, g := range ap.cascade.groups() {
+		for _, name := range g.Members {
+			add(g.Name, name)
+		}
+	}
+	for _, r := range runners {
+		for _, group := range r.groups {
+			add(group, r.name)
+		}
+	}
+	return members
+}
+
+// cascadeFrom runs the cascade of inc's trigger, or the collections
+// of a rule. With a report queue it runs on its own goroutine so the
+// triggering watcher goes back to its ticks; the goroutine counts in
+// wg, which the watcher holds, so Stop still waits for it.
+func (ap *autoPprof) cascadeFrom(inc incident, targets []*metricRunner) {
+	if ap.queue == nil {
+		ap.runCascade(inc, targets)
+		return
+	}
+	ap.wg.Add(1)
+	go func() {
+		defer ap.wg.Done()
+		ap.runCascade(inc, targets)
+	}()
+}
+
+// runCascade reports targets as part of inc after its trigger fired.
+// The targets are collected regardless of their own thresholds.
+func (ap *autoPprof) runCascade(inc incident, targets []*metricRunner) {
+	for _, r := range targets {
+		ap.runCascadeTarget(inc, r)
+	}
+}
+
+// runCascadeTarget reports one target of runCascade, skipping it if it
+// was unregistered since the cascade began.
+func (ap *autoPprof) runCascadeTarget(inc incident, r *metricRunner) {
+	if !r.acquire() {
+		return
+	}
+	defer r.release()
+	cfg, value, ok := ap.queryCascade(r, inc.triggeredBy)
+	if !ok {
+		return
+	}
+	if _, err := ap.dispatchReport(r, cfg, value, inc); err != nil {
+		ap.logCascadeError(r, inc.triggeredBy, cfg, value, err)
+	}
+}
+
+// queryCascade queries a cascade target; ok is false, the failure
+// reported, if the query failed.
+func (ap *autoPprof) queryCascade(
+	r *metricRunner, triggered string,
+) (cfg MetricConfig, value float64, ok bool) {
+	cfg = r.config()
+	value, err := r.query()
+	if err != nil {
+		callHook(ap.hooks.OnError, Event{
+			MetricName: r.name, Threshold: cfg.Threshold,
+			Phase: phaseQuery, Err: err,
+		})
+		ap.logger.Error("autopprof: cascade query failed",
+			logKeyMetric, r.name,
+			logKeyTriggeredBy, triggered,
+			logKeyPhase, phaseQuery,
+			logKeyError, err,
+		)
+		return cfg, 0, false
+	}
+	return cfg, value, true
+}
+
+// logCascadeError logs a failed report of a cascade target.
+func (ap *autoPprof) logCascadeError(
+	r *metricRunner, triggered string, cfg MetricConfig, value float64, err error,
+) {
+	ap.logger.Error("autopprof: cascade report failed",
+		logKeyMetric, r.name,
+		logKeyTriggeredBy, triggered,
+		logKeyValue, value,
+		logKeyThreshold, cfg.Threshold,
+		logKeyPhase, phaseOf(err),
+		logKeyError, err,
+	)
+}
+
+func (ap *autoPprof) status() []MetricStatus {
+	runners := ap.sortedRunners()
+	members := ap.cascadeMembers(runners)
+	statuses := make([]MetricStatus, len(runners))
+	for i, r := range runners {
+		st := r.status()
+		for _, g := range ap.cascade.groups() {
+			if members[g.Name][r.name] {
+				st.CascadeGroups = append(st.CascadeGroups, g.Name)
+			}
+		}
+		for _, group := range r.groups {
+			if !containsString(st.CascadeGroups, group) {
+				st.CascadeGroups = append(st.CascadeGroups, group)
+			}
+		}
+		st.Cascade = !ap.cascade.Disabled && !st.Disabled && len(st.CascadeGroups) > 0
+		statuses[i] = st
+	}
+	return statuses
+}
+
+// sortedRunners snapshots every registered runner in name order.
+func (ap *autoPprof) sortedRunners() []*metricRunner {
+	ap.mu.Lock()
+	runners := make([]*metricRunner, 0, len(ap.runners))
+	for _, r := range ap.runners {
+		runners = append(runners, r)
+	}
+	ap.mu.Unlock()
+	sort.Slice(runners, func(i, j int) bool {
+		return runners[i].name < runners[j].name
+	})
+	return runners
+}
+
+func (ap *autoPprof) failedMetrics() map[string]error {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	failed := make(map[string]error)
+	for name, r := range ap.runners {
+		if err := r.failed(); err != nil {
+			failed[name] = err
+		}
+	}
+	return failed
 }
 
 // stop signals every watcher and blocks until they exit. wg.Wait
@@ -338,7 +1616,12 @@ func (ap *autoPprof) cascadeBuiltIn(triggered string) {
 // flight.
 func (ap *autoPprof) stop() {
 	ap.stopOnce.Do(func() {
+		ap.mu.Lock()
 		close(ap.stopC)
+		ap.mu.Unlock()
 		ap.wg.Wait()
+		if ap.queue != nil {
+			ap.queue.close()
+		}
 	})
 }
@@ -9,6 +9,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"os"
+	"reflect"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -50,10 +54,70 @@ func (f *fakeMetric) Collect(v float64) (CollectResult, error) {
 	return CollectResult{}, nil
 }
 
+// extFakeMetric adds the optional Metric extensions to fakeMetric.
+type extFakeMetric struct {
+	*fakeMetric
+	cooldown time.Duration
+	reArm    float64
+	sustain  Sustain
+	rate     Rate
+}
+
+func (f *extFakeMetric) Cooldown() time.Duration { return f.cooldown }
+func (f *extFakeMetric) ReArmThreshold() float64 { return f.reArm }
+func (f *extFakeMetric) Sustain() Sustain        { return f.sustain }
+func (f *extFakeMetric) Rate() Rate              { return f.rate }
+
+// sequenceQuery returns a queryFn yielding values in order, repeating
+// the last one forever.
+func sequenceQuery(values ...float64) func() (float64, error) {
+	var i atomic.Int32
+	return func() (float64, error) {
+		n := int(i.Add(1)) - 1
+		if n >= len(values) {
+			n = len(values) - 1
+		}
+		return values[n], nil
+	}
+}
+
+// logRecord is one call captured by recordLogger.
+type logRecord struct {
+	level, msg string
+	fields     map[string]any
+}
+
+// recordLogger is a Logger that keeps every record for assertions.
+type recordLogger struct {
+	mu      sync.Mutex
+	records []logRecord
+}
+
+func (l *recordLogger) add(level, msg string, args []any) {
+	fields := make(map[string]any)
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[fmt.Sprint(args[i])] = args[i+1]
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, logRecord{level: level, msg: msg, fields: fields})
+}
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.add("DEBUG", msg, args) }
+func (l *recordLogger) Info(msg string, args ...any)  { l.add("INFO", msg, args) }
+func (l *recordLogger) Warn(msg string, args ...any)  { l.add("WARN", msg, args) }
+func (l *recordLogger) Error(msg string, args ...any) { l.add("ERROR", msg, args) }
+
+func (l *recordLogger) snapshot() []logRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]logRecord(nil), l.records...)
+}
+
 // resetGlobal ensures test isolation — some tests Start() and don't
 // Stop() cleanly; others test CAS behavior that needs the slot empty.
 func resetGlobal() {
-	globalAp = nil
+	globalProfiler = nil
 	startOnce = sync.Once{}
 	startErr = nil
 	stopOnce = sync.Once{}
@@ -107,6 +171,91 @@ func TestOption_validate(t *testing.T) {
 		{"negative interval",
 			Option{Reporter: stub, Metrics: []Metric{&fakeMetric{nameVal: "x", thresholdVal: 1, intervalVal: -time.Second}}},
 			ErrInvalidMetric},
+		{"negative query error budget",
+			Option{Reporter: stub, QueryErrorPolicy: QueryErrorPolicy{MaxConsecutiveErrors: -1}},
+			ErrInvalidQueryErrorPolicy},
+		{"negative query retry backoff",
+			Option{Reporter: stub, QueryErrorPolicy: QueryErrorPolicy{InitialBackoff: -time.Second}},
+			ErrInvalidQueryErrorPolicy},
+		{"re-arm above default threshold",
+			Option{Reporter: stub, CPUReArmThreshold: 0.9},
+			ErrInvalidReArmThreshold},
+		{"re-arm above goroutine threshold",
+			Option{Reporter: stub, GoroutineThreshold: 100, GoroutineReArmThreshold: 200},
+			ErrInvalidReArmThreshold},
+		{"negative watch interval",
+			Option{Reporter: stub, WatchInterval: -time.Second},
+			ErrInvalidWatchInterval},
+		{"negative cpu profiling duration",
+			Option{Reporter: stub, CPUProfilingDuration: -time.Second},
+			ErrInvalidCPUProfilingDuration},
+		{"negative min consecutive over threshold",
+			Option{Reporter: stub, MinConsecutiveOverThreshold: -1},
+			ErrInvalidMinConsecutiveOverThreshold},
+		{"negative cpu usage window",
+			Option{Reporter: stub, CPUUsageWindow: -time.Minute},
+			ErrInvalidCPUUsageWindow},
+		{"batch tuning",
+			Option{Reporter: stub, WatchInterval: time.Second, CPUProfilingDuration: 30 * time.Second,
+				MinConsecutiveOverThreshold: 60, CPUUsageWindow: time.Minute},
+			nil},
+		{"sustain samples above window",
+			Option{Reporter: stub, MemSustain: Sustain{Samples: 4, Window: 3}},
+			ErrInvalidSustain},
+		{"negative sustain duration",
+			Option{Reporter: stub, CPUSustain: Sustain{For: -time.Second}},
+			ErrInvalidSustain},
+		{"custom sustain invalid",
+			Option{Reporter: stub, Metrics: []Metric{&extFakeMetric{fakeMetric: &fakeMetric{nameVal: "x", thresholdVal: 1}, sustain: Sustain{Samples: -1}}}},
+			ErrInvalidMetric},
+		{"rate without window",
+			Option{Reporter: stub, MemRate: Rate{Delta: 0.1}},
+			ErrInvalidRate},
+		{"negative rate",
+			Option{Reporter: stub, GoroutineRate: Rate{Delta: -1, Over: time.Minute}},
+			ErrInvalidRate},
+		{"anomaly alpha above 1",
+			Option{Reporter: stub, CPUAnomaly: Anomaly{K: 3, Alpha: 1.5}},
+			ErrInvalidAnomaly},
+		{"rule with an empty condition",
+			Option{Reporter: stub, Rules: []Rule{{Name: "r", When: All()}}},
+			ErrInvalidRule},
+		{"duplicate rule names",
+			Option{Reporter: stub, Rules: []Rule{{Name: "r", When: Above("cpu", 0.7)}, {Name: "r", When: Above("mem", 0.7)}}},
+			ErrInvalidRule},
+		{"cascade group without name",
+			Option{Reporter: stub, Cascade: CascadePolicy{Groups: []CascadeGroup{{Members: []string{"cpu"}}}}},
+			ErrInvalidCascadeGroup},
+		{"duplicate cascade group",
+			Option{Reporter: stub, Cascade: CascadePolicy{Groups: []CascadeGroup{{Name: "g"}, {Name: "g"}}}},
+			ErrInvalidCascadeGroup},
+		{"empty cascade member",
+			Option{Reporter: stub, Cascade: CascadePolicy{Groups: []CascadeGroup{{Name: "g", Triggers: []string{""}}}}},
+			ErrInvalidCascadeGroup},
+		{"negative report queue size",
+			Option{Reporter: stub, ReportQueue: ReportQueue{Size: -1}},
+			ErrInvalidReportQueue},
+		{"unknown overflow policy",
+			Option{Reporter: stub, ReportQueue: ReportQueue{Size: 1, Overflow: OverflowBlock + 1}},
+			ErrInvalidReportQueue},
+		{"negative spool size",
+			Option{Reporter: stub, Spool: SpoolOption{Dir: "spool", MaxBytes: -1}},
+			ErrInvalidSpool},
+		{"bad comment template",
+			Option{Reporter: stub, CommentTemplate: "{{.Value"},
+			ErrInvalidTemplate},
+		{"bad filename template",
+			Option{Reporter: stub, FilenameTemplate: "{{nosuchfunc .App}}"},
+			ErrInvalidTemplate},
+		{"unknown bundle format",
+			Option{Reporter: stub, Cascade: CascadePolicy{Bundle: BundleZip + 1}},
+			ErrInvalidBundleFormat},
+		{"negative cooldown",
+			Option{Reporter: stub, GlobalCooldown: -time.Second},
+			ErrInvalidCooldown},
+		{"custom re-arm above threshold",
+			Option{Reporter: stub, Metrics: []Metric{&extFakeMetric{fakeMetric: &fakeMetric{nameVal: "x", thresholdVal: 1}, reArm: 2}}},
+			ErrInvalidMetric},
 		{"valid custom metric",
 			Option{Reporter: stub, Metrics: []Metric{validMetric}},
 			nil},
@@ -132,7 +281,8 @@ func newTestAp(t *testing.T, reporter report.Reporter) *autoPprof {
 		minConsecutiveOverThreshold: 3,
 		reporter:                    reporter,
 		reportTimeout:               defaultReportTimeout,
-		cascadedRunners:             make(map[string]*metricRunner),
+		logger:                      stdLogger{},
+		runners:                     make(map[string]*metricRunner),
 		stopC:                       make(chan struct{}),
 	}
 }
@@ -246,333 +396,2061 @@ func TestWatchMetric_builtinGoroutine_routesToReporter(t *testing.T) {
 	}
 }
 
-// -------------------------------------------------------------------
-// Debounce (minConsecutiveOverThreshold)
-// -------------------------------------------------------------------
-
-func TestWatchMetric_debounce(t *testing.T) {
+func TestCollectJob_templates(t *testing.T) {
 	ctrl := gomock.NewController(t)
-	var reported atomic.Int32
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().MemUsage().AnyTimes().Return(0.9, nil)
+	mockProf := NewMockprofiler(ctrl)
+	mockProf.EXPECT().profileHeap().AnyTimes().Return([]byte("heap-bytes"), nil)
+	var infos []report.ReportInfo
 	mockReporter := report.NewMockReporter(ctrl)
 	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
 		AnyTimes().
-		DoAndReturn(func(_ context.Context, _ io.Reader, _ report.ReportInfo) error {
-			reported.Add(1)
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			infos = append(infos, info)
 			return nil
 		})
 
-	fm := &fakeMetric{
-		nameVal:      "dbn",
-		thresholdVal: 10,
-		intervalVal:  20 * time.Millisecond,
-		queryFn:      func() (float64, error) { return 100, nil },
-		collectFn: func(float64) (CollectResult, error) {
-			return CollectResult{Reader: bytes.NewReader([]byte("x")), Filename: "f", Comment: "c"}, nil
-		},
-	}
 	ap := newTestAp(t, mockReporter)
-	ap.minConsecutiveOverThreshold = 3
-	if err := ap.registerMetric(fm); err != nil {
+	ap.watchInterval = time.Hour
+	ap.app = "web"
+	ap.labels = map[string]string{"env": "prod"}
+	var err error
+	ap.commentTmpl, err = parseTemplate("CommentTemplate",
+		`[{{.MetricName}}] {{percent .Value}}% > {{percent .Threshold}}% env={{.Labels.env}} go={{.Build.GoVersion}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap.filenameTmpl, err = parseTemplate("FilenameTemplate",
+		`{{.App}}/{{.Time.Format "2006"}}/{{.Hostname}}/{{.MetricName}}{{if .BuiltIn}}.pprof{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap.registerBuiltIn(&memMetric{threshold: 0.75, cg: mockCG, p: mockProf})
+	custom := &fakeMetric{nameVal: "pool", thresholdVal: 1, intervalVal: time.Hour,
+		queryFn: func() (float64, error) { return 2, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x")), Filename: "pool.dump"}, nil
+		}}
+	if err := ap.registerMetric(custom); err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { ap.stop() })
 
-	// Five ticks @20ms ≈ 100ms — first and fourth ticks should fire.
-	time.Sleep(170 * time.Millisecond)
-	got := reported.Load()
-	if got < 2 || got > 3 {
-		t.Errorf("expected 2-3 reports with debounce=3, got %d", got)
+	if _, err := ap.captureAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mem, pool := infos[0], infos[1]
+	wantMem := fmt.Sprintf("web/%d/%s/mem.pprof", time.Now().Year(), hostnameSafe())
+	if mem.Filename != wantMem {
+		t.Errorf("mem Filename = %q, want %q", mem.Filename, wantMem)
+	}
+	wantComment := "[mem] 90.00% > 75.00% env=prod go=" + runtime.Version()
+	if mem.Comment != wantComment {
+		t.Errorf("mem Comment = %q, want %q", mem.Comment, wantComment)
+	}
+	// Custom metrics keep the Filename they return.
+	if pool.Filename != "pool.dump" || !strings.HasPrefix(pool.Comment, "[pool] 200.00% > 100.00%") {
+		t.Errorf("unexpected pool info: %+v", pool)
 	}
 }
 
-// -------------------------------------------------------------------
-// Cascade
-// -------------------------------------------------------------------
-
-func TestCascadeBuiltIn(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
-	mockCG.EXPECT().CPUUsage().AnyTimes().Return(0.9, nil)
-	mockCG.EXPECT().MemUsage().AnyTimes().Return(0.1, nil) // below threshold
-	mockRT := queryer.NewMockRuntimeQueryer(ctrl)
-	mockRT.EXPECT().GoroutineCount().AnyTimes().Return(1) // below threshold
-	mockProf := NewMockprofiler(ctrl)
-	mockProf.EXPECT().profileCPU().AnyTimes().Return([]byte("c"), nil)
-	mockProf.EXPECT().profileHeap().AnyTimes().Return([]byte("h"), nil)
-	mockProf.EXPECT().profileGoroutine().AnyTimes().Return([]byte("g"), nil)
-
-	var cpuCnt, memCnt, goCnt atomic.Int32
-	mockReporter := report.NewMockReporter(ctrl)
+func TestCollectJob_reportInfo(t *testing.T) {
+	var got report.ReportInfo
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
 	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
-		AnyTimes().
+		Times(1).
 		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
-			switch info.MetricName {
-			case "cpu":
-				cpuCnt.Add(1)
-			case "mem":
-				memCnt.Add(1)
-			case "goroutine":
-				goCnt.Add(1)
-			}
+			got = info
 			return nil
 		})
 
 	ap := newTestAp(t, mockReporter)
-	ap.cgroupQueryer = mockCG
-	ap.runtimeQueryer = mockRT
-	ap.profiler = mockProf
-	ap.minConsecutiveOverThreshold = 1000
-	ap.registerBuiltIn(&cpuMetric{threshold: 0.5, cg: mockCG, p: mockProf})
-	ap.registerBuiltIn(&memMetric{threshold: 0.5, cg: mockCG, p: mockProf})
-	ap.registerBuiltIn(&goroutineMetric{threshold: 5, rt: mockRT, p: mockProf})
+	ap.app = "web"
+	ap.labels = map[string]string{"env": "prod"}
+	fm := &fakeMetric{nameVal: "pool", thresholdVal: 1, intervalVal: time.Hour,
+		queryFn: func() (float64, error) { return 0, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			time.Sleep(5 * time.Millisecond)
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		}}
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
 	t.Cleanup(func() { ap.stop() })
 
-	// Only CPU is over threshold; cascade should fire Mem and Goroutine too.
-	waitFor(t, func() bool {
-		return cpuCnt.Load() > 0 && memCnt.Load() > 0 && goCnt.Load() > 0
-	}, 2*time.Second)
-	if cpuCnt.Load() == 0 || memCnt.Load() == 0 || goCnt.Load() == 0 {
-		t.Errorf("cascade should fire all built-ins cpu=%d mem=%d goroutine=%d",
-			cpuCnt.Load(), memCnt.Load(), goCnt.Load())
+	before := time.Now()
+	if _, err := ap.capture(context.Background(), "pool"); err != nil {
+		t.Fatal(err)
+	}
+	if got.StartTime.Before(before) || got.EndTime.Sub(got.StartTime) < 5*time.Millisecond {
+		t.Errorf("capture window = [%v, %v], want from %v and at least 5ms", got.StartTime, got.EndTime, before)
+	}
+	build := currentBuildInfo()
+	if got.Hostname != hostnameSafe() || got.PID != os.Getpid() || got.App != "web" ||
+		got.GoVersion != runtime.Version() ||
+		got.BuildVersion != build.Version || got.BuildRevision != build.Revision {
+		t.Errorf("unexpected ReportInfo: %+v", got)
+	}
+	if got.Labels["env"] != "prod" {
+		t.Fatalf("Labels = %v", got.Labels)
+	}
+	// Each report gets its own copy of the labels.
+	got.Labels["env"] = "dev"
+	if ap.labels["env"] != "prod" {
+		t.Error("a Reporter mutated Option.Labels")
 	}
 }
 
 // -------------------------------------------------------------------
-// User metric: trigger, independence, interval, nil reader, defaults
+// Debounce (minConsecutiveOverThreshold)
 // -------------------------------------------------------------------
 
-func TestUserMetric_trigger(t *testing.T) {
+func TestWatchMetric_debounce(t *testing.T) {
 	ctrl := gomock.NewController(t)
-	var infoSeen report.ReportInfo
 	var reported atomic.Int32
 	mockReporter := report.NewMockReporter(ctrl)
 	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
 		AnyTimes().
-		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
-			infoSeen = info
+		DoAndReturn(func(_ context.Context, _ io.Reader, _ report.ReportInfo) error {
 			reported.Add(1)
 			return nil
 		})
 
 	fm := &fakeMetric{
-		nameVal:      "mycustom",
+		nameVal:      "dbn",
 		thresholdVal: 10,
 		intervalVal:  20 * time.Millisecond,
-		queryFn:      func() (float64, error) { return 42, nil },
-		collectFn: func(v float64) (CollectResult, error) {
-			return CollectResult{
-				Reader:   bytes.NewReader([]byte("custom-bytes")),
-				Filename: "user.dump",
-				Comment:  "user comment",
-			}, nil
+		queryFn:      func() (float64, error) { return 100, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x")), Filename: "f", Comment: "c"}, nil
 		},
 	}
 	ap := newTestAp(t, mockReporter)
+	ap.minConsecutiveOverThreshold = 3
 	if err := ap.registerMetric(fm); err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { ap.stop() })
 
-	waitFor(t, func() bool { return reported.Load() > 0 }, time.Second)
-
-	if infoSeen.MetricName != "mycustom" || infoSeen.Filename != "user.dump" ||
-		infoSeen.Comment != "user comment" || infoSeen.Value != 42 ||
-		infoSeen.Threshold != 10 {
-		t.Errorf("unexpected info: %+v", infoSeen)
+	// Five ticks @20ms ≈ 100ms — first and fourth ticks should fire.
+	time.Sleep(170 * time.Millisecond)
+	got := reported.Load()
+	if got < 2 || got > 3 {
+		t.Errorf("expected 2-3 reports with debounce=3, got %d", got)
 	}
 }
 
-func TestUserMetric_independent_noCascade(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
-	mockCG.EXPECT().CPUUsage().AnyTimes().Return(0.1, nil)
-	mockRT := queryer.NewMockRuntimeQueryer(ctrl)
-	mockRT.EXPECT().GoroutineCount().AnyTimes().Return(1)
-	mockProf := NewMockprofiler(ctrl)
+// -------------------------------------------------------------------
+// Cooldown & hysteresis
+// -------------------------------------------------------------------
 
-	var names []string
-	var mu sync.Mutex
-	mockReporter := report.NewMockReporter(ctrl)
+func countingReporter(t *testing.T, cnt *atomic.Int32) *report.MockReporter {
+	t.Helper()
+	r := report.NewMockReporter(gomock.NewController(t))
+	r.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, _ report.ReportInfo) error {
+			cnt.Add(1)
+			return nil
+		})
+	return r
+}
+
+func byteCollect(float64) (CollectResult, error) {
+	return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+}
+
+func TestWatchMetric_below(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		infos []report.ReportInfo
+	)
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
 	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
 		AnyTimes().
 		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
 			mu.Lock()
-			names = append(names, info.MetricName)
+			infos = append(infos, info)
 			mu.Unlock()
 			return nil
 		})
-
+	var queries atomic.Int32
+	query := sequenceQuery(0.9, 0.4, 0.9, 0.5, 0.3, 0.8, 0.2, 1)
+	m := NewMetric("hit_ratio", 0.5, 5*time.Millisecond,
+		func() (float64, error) {
+			queries.Add(1)
+			return query()
+		},
+		byteCollect,
+		WithDirection(DirectionBelow),
+	)
 	ap := newTestAp(t, mockReporter)
-	ap.cgroupQueryer = mockCG
-	ap.runtimeQueryer = mockRT
-	ap.profiler = mockProf
-	// Built-in cascade must not pick up user metrics.
-	ap.registerBuiltIn(&cpuMetric{threshold: 0.5, cg: mockCG, p: mockProf})
+	ap.minConsecutiveOverThreshold = 1000
+	if err := ap.registerMetric(m); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
 
-	fm := &fakeMetric{
-		nameVal: "customonly", thresholdVal: 10, intervalVal: 20 * time.Millisecond,
-		queryFn: func() (float64, error) { return 100, nil },
-		collectFn: func(float64) (CollectResult, error) {
-			return CollectResult{Reader: bytes.NewReader([]byte("b"))}, nil
-		},
+	waitFor(t, func() bool { return queries.Load() > 8 }, time.Second)
+	mu.Lock()
+	defer mu.Unlock()
+	// 0.4, 0.5 and 0.2 each follow a value above 0.5; 0.3 is debounced.
+	if len(infos) != 3 {
+		t.Fatalf("reported %d times, want 3", len(infos))
 	}
-	if err := ap.registerMetric(fm); err != nil {
+	if info := infos[0]; info.Value != 0.4 || info.Direction != "below" ||
+		info.Comment != ":rotating_light:[hit_ratio] value=0.40 below threshold=0.50" {
+		t.Errorf("unexpected ReportInfo: %+v", info)
+	}
+	if st := ap.status(); st[0].Direction != DirectionBelow {
+		t.Errorf("Status Direction = %v, want below", st[0].Direction)
+	}
+}
+
+func TestUpdate_keepsDirection(t *testing.T) {
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	m := NewMetric("hit_ratio", 0.5, time.Hour,
+		func() (float64, error) { return 0.9, nil },
+		byteCollect,
+		WithDirection(DirectionBelow),
+	)
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(m); err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { ap.stop() })
 
-	waitFor(t, func() bool {
-		mu.Lock()
-		defer mu.Unlock()
-		for _, n := range names {
-			if n == "customonly" {
-				return true
-			}
+	for _, cfg := range []MetricConfig{
+		{Threshold: 0.4},
+		{Threshold: 0.3, Direction: DirectionBelow},
+	} {
+		if err := ap.updateMetric("hit_ratio", cfg); err != nil {
+			t.Fatal(err)
 		}
-		return false
-	}, time.Second)
-
-	mu.Lock()
-	defer mu.Unlock()
-	for _, n := range names {
-		if n == "cpu" {
-			t.Errorf("custom trigger caused cpu cascade: %v", names)
-			return
+		if st := ap.status(); st[0].Direction != DirectionBelow || st[0].Threshold != cfg.Threshold {
+			t.Errorf("after Update(%+v), Status = %+v, want below", cfg, st[0])
 		}
 	}
+
+	above := NewMetric("qps", 100, time.Hour, func() (float64, error) { return 1, nil }, byteCollect)
+	if err := ap.registerMetric(above); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.updateMetric("qps", MetricConfig{Threshold: 10, Direction: DirectionBelow}); !errors.Is(err, ErrInvalidMetricConfig) {
+		t.Errorf("flipping the direction: want ErrInvalidMetricConfig, got %v", err)
+	}
 }
 
-func TestUserMetric_perMetricInterval(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	var reported atomic.Int32
-	mockReporter := report.NewMockReporter(ctrl)
-	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
-		AnyTimes().
-		DoAndReturn(func(_ context.Context, _ io.Reader, _ report.ReportInfo) error {
-			reported.Add(1)
-			return nil
+func TestWatchMetric_hysteresis(t *testing.T) {
+	testCases := []struct {
+		name  string
+		reArm float64
+		want  int32
+	}{
+		// Every dip below 10 re-arms: 11 fires four times.
+		{"legacy re-arm at threshold", 0, 4},
+		// Dips to 9 stay in the band; only the drop to 4 re-arms.
+		{"re-arm below 5", 5, 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var reported atomic.Int32
+			fm := &extFakeMetric{
+				fakeMetric: &fakeMetric{nameVal: "osc", thresholdVal: 10, intervalVal: 5 * time.Millisecond,
+					queryFn:   sequenceQuery(11, 9, 11, 9, 11, 4, 11, 0),
+					collectFn: byteCollect},
+				reArm: tc.reArm,
+			}
+			ap := newTestAp(t, countingReporter(t, &reported))
+			ap.minConsecutiveOverThreshold = 1000
+			if err := ap.registerMetric(fm); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { ap.stop() })
+
+			waitFor(t, func() bool { return fm.queryCalls.Load() > 8 }, time.Second)
+			if got := reported.Load(); got != tc.want {
+				t.Errorf("reported %d times, want %d", got, tc.want)
+			}
 		})
+	}
+}
 
-	fm := &fakeMetric{
-		nameVal: "fast", thresholdVal: 1,
-		intervalVal: 10 * time.Millisecond, // much faster than global
-		queryFn:     func() (float64, error) { return 10, nil },
-		collectFn: func(float64) (CollectResult, error) {
-			return CollectResult{Reader: bytes.NewReader([]byte("b"))}, nil
-		},
+func TestWatchMetric_perMetricCooldown(t *testing.T) {
+	var reported atomic.Int32
+	fm := &extFakeMetric{
+		fakeMetric: &fakeMetric{nameVal: "cool", thresholdVal: 1, intervalVal: 10 * time.Millisecond,
+			queryFn: func() (float64, error) { return 10, nil }, collectFn: byteCollect},
+		cooldown: 100 * time.Millisecond,
 	}
-	ap := newTestAp(t, mockReporter)
-	ap.watchInterval = 10 * time.Second // global should be ignored
+	ap := newTestAp(t, countingReporter(t, &reported))
+	ap.minConsecutiveOverThreshold = 1 // would fire on every tick
+	ap.cooldown = time.Hour            // overridden by the metric
 	if err := ap.registerMetric(fm); err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { ap.stop() })
 
-	waitFor(t, func() bool { return reported.Load() > 0 }, 500*time.Millisecond)
+	time.Sleep(250 * time.Millisecond)
+	if got := reported.Load(); got < 2 || got > 3 {
+		t.Errorf("expected 2-3 reports with a 100ms cooldown, got %d", got)
+	}
 }
 
-func TestUserMetric_nilReaderSkipsReporter(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	mockReporter := report.NewMockReporter(ctrl)
-	// If Report is ever called, the test fails.
-	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+func TestWatchMetric_globalCooldown(t *testing.T) {
+	var reported atomic.Int32
+	ap := newTestAp(t, countingReporter(t, &reported))
+	ap.minConsecutiveOverThreshold = 1
+	ap.globalCooldown = time.Hour
+	for _, name := range []string{"a", "b"} {
+		fm := &fakeMetric{nameVal: name, thresholdVal: 1, intervalVal: 10 * time.Millisecond,
+			queryFn: func() (float64, error) { return 10, nil }, collectFn: byteCollect}
+		if err := ap.registerMetric(fm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { ap.stop() })
 
-	fm := &fakeMetric{
+	time.Sleep(100 * time.Millisecond)
+	if got := reported.Load(); got != 1 {
+		t.Errorf("reported %d times across metrics, want 1", got)
+	}
+}
+
+func TestCPUUsageSnapshots(t *testing.T) {
+	testCases := []struct {
+		window, interval time.Duration
+		want             int
+	}{
+		{defaultCPUUsageWindow, defaultWatchInterval, 24},
+		{time.Minute, time.Second, 60},
+		{10 * time.Second, 3 * time.Second, 3},
+	}
+	for _, tc := range testCases {
+		if got := cpuUsageSnapshots(tc.window, tc.interval); got != tc.want {
+			t.Errorf("cpuUsageSnapshots(%v, %v) = %d, want %d", tc.window, tc.interval, got, tc.want)
+		}
+	}
+}
+
+// -------------------------------------------------------------------
+// Sustained breach
+// -------------------------------------------------------------------
+
+func TestBreachTracker_observe(t *testing.T) {
+	t0 := time.Now()
+	testCases := []struct {
+		name    string
+		sustain Sustain
+		samples []bool
+		want    []bool
+	}{
+		{
+			name:    "zero fires on every breach",
+			samples: []bool{true, false, true},
+			want:    []bool{true, false, true},
+		},
+		{
+			name:    "3 consecutive",
+			sustain: Sustain{Samples: 3},
+			samples: []bool{true, true, false, true, true, true, true},
+			want:    []bool{false, false, false, false, false, true, true},
+		},
+		{
+			name:    "2 of 3",
+			sustain: Sustain{Samples: 2, Window: 3},
+			samples: []bool{true, false, true, false, false, true},
+			want:    []bool{false, false, true, false, false, false},
+		},
+		{
+			// One sample per second.
+			name:    "for 2s",
+			sustain: Sustain{For: 2 * time.Second},
+			samples: []bool{true, true, true, false, true, true, true},
+			want:    []bool{false, false, true, false, false, false, true},
+		},
+		{
+			name:    "for 1s and 2 of 4",
+			sustain: Sustain{For: time.Second, Samples: 2, Window: 4},
+			samples: []bool{true, false, true, true},
+			want:    []bool{false, false, false, true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var tr breachTracker
+			for i, breach := range tc.samples {
+				now := t0.Add(time.Duration(i) * time.Second)
+				if got := tr.observe(tc.sustain, breach, now); got != tc.want[i] {
+					t.Errorf("sample %d: observe() = %v, want %v", i, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBreachTracker_sustainChangeStartsOver(t *testing.T) {
+	var tr breachTracker
+	now := time.Now()
+	tr.observe(Sustain{Samples: 2}, true, now)
+	if tr.observe(Sustain{Samples: 3}, true, now) {
+		t.Error("a new Sustain should not count samples seen under the old one")
+	}
+}
+
+func TestWatchMetric_sustain(t *testing.T) {
+	testCases := []struct {
+		name   string
+		values []float64
+		want   int32
+	}{
+		{"spikes never fire", []float64{10, 0, 10, 0, 10, 0}, 0},
+		{"sustained breach fires once", []float64{10, 10, 10, 10, 10, 0}, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var reported atomic.Int32
+			fm := &extFakeMetric{
+				fakeMetric: &fakeMetric{nameVal: "spiky", thresholdVal: 5, intervalVal: 5 * time.Millisecond,
+					queryFn: sequenceQuery(tc.values...), collectFn: byteCollect},
+				sustain: Sustain{Samples: 3},
+			}
+			ap := newTestAp(t, countingReporter(t, &reported))
+			ap.minConsecutiveOverThreshold = 1000
+			if err := ap.registerMetric(fm); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { ap.stop() })
+
+			waitFor(t, func() bool { return fm.queryCalls.Load() > int64(len(tc.values)) }, time.Second)
+			if got := reported.Load(); got != tc.want {
+				t.Errorf("reported %d times, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// -------------------------------------------------------------------
+// Rate of change
+// -------------------------------------------------------------------
+
+func TestRateTracker_observe(t *testing.T) {
+	t0 := time.Now()
+	rate := Rate{Delta: 5, Over: 2 * time.Second}
+	testCases := []struct {
+		name       string
+		direction  Direction
+		values     []float64 // one sample per second
+		wantChange []float64
+	}{
+		{"growth", DirectionAbove, []float64{10, 13, 16, 17}, []float64{0, 3, 6, 4}},
+		{"growth from the window low", DirectionAbove, []float64{10, 4, 8, 11}, []float64{0, 0, 4, 7}},
+		{"fall for below", DirectionBelow, []float64{20, 17, 14, 20}, []float64{0, 3, 6, 0}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var tr rateTracker
+			for i, v := range tc.values {
+				change, breach := tr.observe(rate, tc.direction, v, t0.Add(time.Duration(i)*time.Second))
+				if change != tc.wantChange[i] || breach != (tc.wantChange[i] > rate.Delta) {
+					t.Errorf("sample %d: observe() = %v, %v, want change %v", i, change, breach, tc.wantChange[i])
+				}
+			}
+		})
+	}
+
+	var tr rateTracker
+	if _, breach := tr.observe(Rate{}, DirectionAbove, 100, t0); breach {
+		t.Error("the zero Rate must never breach")
+	}
+}
+
+func TestWatchMetric_rate(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		infos []report.ReportInfo
+	)
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			mu.Lock()
+			infos = append(infos, info)
+			mu.Unlock()
+			return nil
+		})
+	// Far below the threshold of 100, but 10 -> 30 is a leak.
+	fm := &extFakeMetric{
+		fakeMetric: &fakeMetric{nameVal: "leak", thresholdVal: 100, intervalVal: 5 * time.Millisecond,
+			queryFn: sequenceQuery(10, 20, 30, 30, 30, 30), collectFn: byteCollect},
+		rate: Rate{Delta: 15, Over: time.Hour},
+	}
+	ap := newTestAp(t, mockReporter)
+	ap.minConsecutiveOverThreshold = 1000
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool { return fm.queryCalls.Load() > 6 }, time.Second)
+	mu.Lock()
+	defer mu.Unlock()
+	// The window still holds 10, so 30 stays a breach: debounced.
+	if len(infos) != 1 {
+		t.Fatalf("reported %d times, want 1", len(infos))
+	}
+	info := infos[0]
+	if info.Value != 30 || info.Trigger != report.TriggerRate || info.Change != 20 ||
+		info.Comment != ":rotating_light:[leak] value=30.00 changed by 20.00 in 1h0m0s > rate=15.00" {
+		t.Errorf("unexpected ReportInfo: %+v", info)
+	}
+}
+
+func TestWatchMetric_builtinMemRate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	var i atomic.Int32
+	values := []float64{0.30, 0.35, 0.45}
+	mockCG.EXPECT().MemUsage().AnyTimes().DoAndReturn(func() (float64, error) {
+		n := int(i.Add(1)) - 1
+		if n >= len(values) {
+			n = len(values) - 1
+		}
+		return values[n], nil
+	})
+	mockProf := NewMockprofiler(ctrl)
+	mockProf.EXPECT().profileHeap().AnyTimes().Return([]byte("h"), nil)
+	infoC := make(chan report.ReportInfo, 10)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			infoC <- info
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.watchInterval = 5 * time.Millisecond
+	ap.minConsecutiveOverThreshold = 1000
+	ap.registerBuiltIn(&memMetric{threshold: 0.9, rate: Rate{Delta: 0.1, Over: time.Hour}, cg: mockCG, p: mockProf})
+	t.Cleanup(func() { ap.stop() })
+
+	select {
+	case info := <-infoC:
+		want := ":rotating_light:[MEM] usage (*45.00%*) grew *15.00%* in 1h0m0s > rate (*10.00%*)"
+		if info.Trigger != report.TriggerRate || info.Comment != want {
+			t.Errorf("unexpected ReportInfo: %+v", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("mem growth not reported")
+	}
+}
+
+// -------------------------------------------------------------------
+// Anomaly
+// -------------------------------------------------------------------
+
+// noisy returns n values alternating around base by ±jitter.
+func noisy(n int, base, jitter float64) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = base + jitter
+		if i%2 == 1 {
+			values[i] = base - jitter
+		}
+	}
+	return values
+}
+
+func TestAnomalyTracker_observe(t *testing.T) {
+	testCases := []struct {
+		name      string
+		anomaly   Anomaly
+		direction Direction
+		values    []float64
+		want      bool // whether the last value breaches
+	}{
+		{"spike", Anomaly{K: 3, WarmUp: 20}, DirectionAbove, append(noisy(40, 10, 1), 20), true},
+		{"within noise", Anomaly{K: 3, WarmUp: 20}, DirectionAbove, append(noisy(40, 10, 1), 11.5), false},
+		{"warming up", Anomaly{K: 3, WarmUp: 50}, DirectionAbove, append(noisy(40, 10, 1), 20), false},
+		{"under the floor", Anomaly{K: 3, WarmUp: 20, Floor: 25}, DirectionAbove, append(noisy(40, 10, 1), 20), false},
+		{"drop for below", Anomaly{K: 3, WarmUp: 20}, DirectionBelow, append(noisy(40, 10, 1), 2), true},
+		{"spike for below", Anomaly{K: 3, WarmUp: 20}, DirectionBelow, append(noisy(40, 10, 1), 20), false},
+		// The 1% floor of the deviation keeps a flat line from firing
+		// on a tiny step.
+		{"flat baseline", Anomaly{K: 3, WarmUp: 20}, DirectionAbove, append(noisy(40, 100, 0), 102), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var tr anomalyTracker
+			var breach bool
+			for i, v := range tc.values {
+				_, _, breach = tr.observe(tc.anomaly, tc.direction, v)
+				if i < len(tc.values)-1 && breach {
+					t.Fatalf("sample %d (%v) breached", i, v)
+				}
+			}
+			if breach != tc.want {
+				t.Errorf("last sample breach = %v, want %v", breach, tc.want)
+			}
+		})
+	}
+}
+
+func TestWatchMetric_builtinCPUAnomaly(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	query := sequenceQuery(append(noisy(30, 0.10, 0.01), 0.30)...)
+	mockCG.EXPECT().CPUUsage().AnyTimes().DoAndReturn(query)
+	mockProf := NewMockprofiler(ctrl)
+	mockProf.EXPECT().profileCPU().AnyTimes().Return([]byte("c"), nil)
+	infoC := make(chan report.ReportInfo, 10)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			infoC <- info
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.watchInterval = 2 * time.Millisecond
+	ap.minConsecutiveOverThreshold = 1000
+	ap.registerBuiltIn(&cpuMetric{threshold: 0.75, anomaly: Anomaly{K: 4, WarmUp: 20}, cg: mockCG, p: mockProf})
+	t.Cleanup(func() { ap.stop() })
+
+	select {
+	case info := <-infoC:
+		if info.Value != 0.30 || info.Trigger != report.TriggerAnomaly || info.Deviation <= 4 ||
+			math.Abs(info.Baseline-0.10) > 0.01 ||
+			!strings.HasPrefix(info.Comment, ":rotating_light:[CPU] usage (*30.00%*) is *") ||
+			!strings.Contains(info.Comment, "σ* off baseline (*10.") {
+			t.Errorf("unexpected ReportInfo: %+v", info)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cpu anomaly not reported")
+	}
+}
+
+// -------------------------------------------------------------------
+// Cascade
+// -------------------------------------------------------------------
+
+func TestCascadeBuiltIn(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().CPUUsage().AnyTimes().Return(0.9, nil)
+	mockCG.EXPECT().MemUsage().AnyTimes().Return(0.1, nil) // below threshold
+	mockRT := queryer.NewMockRuntimeQueryer(ctrl)
+	mockRT.EXPECT().GoroutineCount().AnyTimes().Return(1) // below threshold
+	mockProf := NewMockprofiler(ctrl)
+	mockProf.EXPECT().profileCPU().AnyTimes().Return([]byte("c"), nil)
+	mockProf.EXPECT().profileHeap().AnyTimes().Return([]byte("h"), nil)
+	mockProf.EXPECT().profileGoroutine().AnyTimes().Return([]byte("g"), nil)
+
+	var cpuCnt, memCnt, goCnt atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			switch info.MetricName {
+			case "cpu":
+				cpuCnt.Add(1)
+			case "mem":
+				memCnt.Add(1)
+			case "goroutine":
+				goCnt.Add(1)
+			}
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.cgroupQueryer = mockCG
+	ap.runtimeQueryer = mockRT
+	ap.profiler = mockProf
+	ap.minConsecutiveOverThreshold = 1000
+	ap.registerBuiltIn(&cpuMetric{threshold: 0.5, cg: mockCG, p: mockProf})
+	ap.registerBuiltIn(&memMetric{threshold: 0.5, cg: mockCG, p: mockProf})
+	ap.registerBuiltIn(&goroutineMetric{threshold: 5, rt: mockRT, p: mockProf})
+	t.Cleanup(func() { ap.stop() })
+
+	// Only CPU is over threshold; cascade should fire Mem and Goroutine too.
+	waitFor(t, func() bool {
+		return cpuCnt.Load() > 0 && memCnt.Load() > 0 && goCnt.Load() > 0
+	}, 2*time.Second)
+	if cpuCnt.Load() == 0 || memCnt.Load() == 0 || goCnt.Load() == 0 {
+		t.Errorf("cascade should fire all built-ins cpu=%d mem=%d goroutine=%d",
+			cpuCnt.Load(), memCnt.Load(), goCnt.Load())
+	}
+}
+
+// cascadeMetric is a fakeMetric that joins cascade groups.
+type cascadeMetric struct {
+	*fakeMetric
+	groups []string
+}
+
+func (c *cascadeMetric) CascadeGroups() []string { return c.groups }
+
+// newCascadeTestAp returns an instance with the three built-ins
+// registered, only cpu over its threshold, and a reporter recording
+// the reported metric names.
+func newCascadeTestAp(t *testing.T, policy CascadePolicy) (*autoPprof, func() map[string]int) {
+	t.Helper()
+	ctrl := gomock.NewController(t)
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().CPUUsage().AnyTimes().Return(0.9, nil)
+	mockCG.EXPECT().MemUsage().AnyTimes().Return(0.1, nil)
+	mockRT := queryer.NewMockRuntimeQueryer(ctrl)
+	mockRT.EXPECT().GoroutineCount().AnyTimes().Return(1)
+	mockProf := NewMockprofiler(ctrl)
+	mockProf.EXPECT().profileCPU().AnyTimes().Return([]byte("c"), nil)
+	mockProf.EXPECT().profileHeap().AnyTimes().Return([]byte("h"), nil)
+	mockProf.EXPECT().profileGoroutine().AnyTimes().Return([]byte("g"), nil)
+
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			mu.Lock()
+			counts[info.MetricName]++
+			mu.Unlock()
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.cgroupQueryer = mockCG
+	ap.runtimeQueryer = mockRT
+	ap.profiler = mockProf
+	ap.minConsecutiveOverThreshold = 1000
+	ap.cascade = policy
+	ap.registerBuiltIn(&cpuMetric{threshold: 0.5, cg: mockCG, p: mockProf})
+	ap.registerBuiltIn(&memMetric{threshold: 0.5, cg: mockCG, p: mockProf})
+	ap.registerBuiltIn(&goroutineMetric{threshold: 5, rt: mockRT, p: mockProf})
+	t.Cleanup(func() { ap.stop() })
+
+	return ap, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		out := make(map[string]int, len(counts))
+		for k, v := range counts {
+			out[k] = v
+		}
+		return out
+	}
+}
+
+func TestCascade_disabled(t *testing.T) {
+	ap, counts := newCascadeTestAp(t, CascadePolicy{Disabled: true})
+
+	waitFor(t, func() bool { return counts()["cpu"] > 0 }, 2*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if got := counts(); got["mem"] != 0 || got["goroutine"] != 0 {
+		t.Errorf("disabled cascade reported %v", got)
+	}
+	for _, st := range ap.status() {
+		if st.Cascade {
+			t.Errorf("%s: Cascade = true with the cascade disabled", st.Name)
+		}
+	}
+}
+
+func TestCascade_groupWithCustomMetric(t *testing.T) {
+	ap, counts := newCascadeTestAp(t, CascadePolicy{Groups: []CascadeGroup{
+		{Name: "cpu-spike", Triggers: []string{"cpu"}, Members: []string{"goroutine"}},
+	}})
+	dbPool := &cascadeMetric{
+		fakeMetric: &fakeMetric{nameVal: "db_pool", thresholdVal: 100, intervalVal: time.Hour,
+			queryFn: func() (float64, error) { return 1, nil }, collectFn: byteCollect},
+		groups: []string{"cpu-spike"},
+	}
+	if err := ap.registerMetric(dbPool); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		got := counts()
+		return got["goroutine"] > 0 && got["db_pool"] > 0
+	}, 2*time.Second)
+	if got := counts(); got["cpu"] == 0 || got["mem"] != 0 {
+		t.Errorf("cpu-spike should report cpu, goroutine and db_pool only: %v", got)
+	}
+
+	st := ap.status()
+	// Sorted: cpu, db_pool, goroutine, mem.
+	if !st[1].Cascade || !reflect.DeepEqual(st[1].CascadeGroups, []string{"cpu-spike"}) {
+		t.Errorf("unexpected db_pool status: %+v", st[1])
+	}
+	if st[3].Cascade || st[3].CascadeGroups != nil {
+		t.Errorf("mem is in no group: %+v", st[3])
+	}
+}
+
+func TestCascade_undeclaredGroup(t *testing.T) {
+	ap, counts := newCascadeTestAp(t, CascadePolicy{Groups: []CascadeGroup{}})
+	mk := func(name string, value float64) *cascadeMetric {
+		return &cascadeMetric{
+			fakeMetric: &fakeMetric{nameVal: name, thresholdVal: 10, intervalVal: 10 * time.Millisecond,
+				queryFn: func() (float64, error) { return value, nil }, collectFn: byteCollect},
+			groups: []string{"pair"},
+		}
+	}
+	for _, m := range []Metric{mk("a", 20), mk("b", 0)} {
+		if err := ap.registerMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, func() bool { return counts()["b"] > 0 }, 2*time.Second)
+	if got := counts(); got["a"] == 0 || got["mem"] != 0 || got["goroutine"] != 0 {
+		t.Errorf("a should cascade to b only, and cpu to nothing: %v", got)
+	}
+}
+
+func TestCascade_incident(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		infos []report.ReportInfo
+	)
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			mu.Lock()
+			infos = append(infos, info)
+			mu.Unlock()
+			return nil
+		})
+	ap := newTestAp(t, mockReporter)
+	ap.cascade = CascadePolicy{Groups: []CascadeGroup{}}
+	mk := func(name string, value float64, interval time.Duration) *cascadeMetric {
+		return &cascadeMetric{
+			fakeMetric: &fakeMetric{nameVal: name, thresholdVal: 10, intervalVal: interval,
+				queryFn: func() (float64, error) { return value, nil }, collectFn: byteCollect},
+			groups: []string{"pair"},
+		}
+	}
+	for _, m := range []Metric{mk("a", 20, 10*time.Millisecond), mk("b", 0, time.Hour)} {
+		if err := ap.registerMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(infos) >= 2
+	}, 2*time.Second)
+	mu.Lock()
+	trigger, cascaded := infos[0], infos[1]
+	mu.Unlock()
+	if trigger.MetricName != "a" || trigger.TriggeredBy != "a" || trigger.IsCascade || trigger.IncidentID == "" ||
+		trigger.Trigger != report.TriggerThreshold {
+		t.Errorf("unexpected trigger report: %+v", trigger)
+	}
+	if cascaded.MetricName != "b" || cascaded.TriggeredBy != "a" || !cascaded.IsCascade ||
+		cascaded.IncidentID != trigger.IncidentID {
+		t.Errorf("cascade report not tied to the trigger: %+v", cascaded)
+	}
+
+	captured, err := ap.captureAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured[0].IncidentID == trigger.IncidentID || captured[0].IncidentID != captured[1].IncidentID ||
+		captured[0].TriggeredBy != "" || captured[0].IsCascade {
+		t.Errorf("CaptureAll should share a new incident: %+v", captured)
+	}
+}
+
+// -------------------------------------------------------------------
+// User metric: trigger, independence, interval, nil reader, defaults
+// -------------------------------------------------------------------
+
+func TestUserMetric_trigger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var infoSeen report.ReportInfo
+	var reported atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			infoSeen = info
+			reported.Add(1)
+			return nil
+		})
+
+	fm := &fakeMetric{
+		nameVal:      "mycustom",
+		thresholdVal: 10,
+		intervalVal:  20 * time.Millisecond,
+		queryFn:      func() (float64, error) { return 42, nil },
+		collectFn: func(v float64) (CollectResult, error) {
+			return CollectResult{
+				Reader:   bytes.NewReader([]byte("custom-bytes")),
+				Filename: "user.dump",
+				Comment:  "user comment",
+			}, nil
+		},
+	}
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool { return reported.Load() > 0 }, time.Second)
+
+	if infoSeen.MetricName != "mycustom" || infoSeen.Filename != "user.dump" ||
+		infoSeen.Comment != "user comment" || infoSeen.Value != 42 ||
+		infoSeen.Threshold != 10 {
+		t.Errorf("unexpected info: %+v", infoSeen)
+	}
+}
+
+func TestUserMetric_independent_noCascade(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().CPUUsage().AnyTimes().Return(0.1, nil)
+	mockRT := queryer.NewMockRuntimeQueryer(ctrl)
+	mockRT.EXPECT().GoroutineCount().AnyTimes().Return(1)
+	mockProf := NewMockprofiler(ctrl)
+
+	var names []string
+	var mu sync.Mutex
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			mu.Lock()
+			names = append(names, info.MetricName)
+			mu.Unlock()
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.cgroupQueryer = mockCG
+	ap.runtimeQueryer = mockRT
+	ap.profiler = mockProf
+	// Built-in cascade must not pick up user metrics.
+	ap.registerBuiltIn(&cpuMetric{threshold: 0.5, cg: mockCG, p: mockProf})
+
+	fm := &fakeMetric{
+		nameVal: "customonly", thresholdVal: 10, intervalVal: 20 * time.Millisecond,
+		queryFn: func() (float64, error) { return 100, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("b"))}, nil
+		},
+	}
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, n := range names {
+			if n == "customonly" {
+				return true
+			}
+		}
+		return false
+	}, time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, n := range names {
+		if n == "cpu" {
+			t.Errorf("custom trigger caused cpu cascade: %v", names)
+			return
+		}
+	}
+}
+
+func TestUserMetric_perMetricInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var reported atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, _ report.ReportInfo) error {
+			reported.Add(1)
+			return nil
+		})
+
+	fm := &fakeMetric{
+		nameVal: "fast", thresholdVal: 1,
+		intervalVal: 10 * time.Millisecond, // much faster than global
+		queryFn:     func() (float64, error) { return 10, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("b"))}, nil
+		},
+	}
+	ap := newTestAp(t, mockReporter)
+	ap.watchInterval = 10 * time.Second // global should be ignored
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool { return reported.Load() > 0 }, 500*time.Millisecond)
+}
+
+func TestUserMetric_nilReaderSkipsReporter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	// If Report is ever called, the test fails.
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	fm := &fakeMetric{
 		nameVal: "noreport", thresholdVal: 1, intervalVal: 20 * time.Millisecond,
 		queryFn: func() (float64, error) { return 10, nil },
 		collectFn: func(float64) (CollectResult, error) {
-			return CollectResult{Reader: nil}, nil
-		},
+			return CollectResult{Reader: nil}, nil
+		},
+	}
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+	time.Sleep(80 * time.Millisecond)
+	// Test passes if the mock's Times(0) expectation is satisfied by gomock.
+}
+
+func TestUserMetric_defaultFilenameComment(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var info report.ReportInfo
+	var done atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, i report.ReportInfo) error {
+			info = i
+			done.Add(1)
+			return nil
+		})
+
+	fm := &fakeMetric{
+		nameVal: "defaults", thresholdVal: 1, intervalVal: 20 * time.Millisecond,
+		queryFn: func() (float64, error) { return 10, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		},
+	}
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool { return done.Load() > 0 }, time.Second)
+	if !strings.Contains(info.Filename, "defaults.") || !strings.Contains(info.Filename, ".bin") {
+		t.Errorf("default Filename=%q not generated", info.Filename)
+	}
+	if !strings.Contains(info.Comment, "[defaults]") {
+		t.Errorf("default Comment=%q not generated", info.Comment)
+	}
+}
+
+// -------------------------------------------------------------------
+// Register lifecycle
+// -------------------------------------------------------------------
+
+func TestRegister_errNotStarted(t *testing.T) {
+	resetGlobal()
+	m := &fakeMetric{nameVal: "x", thresholdVal: 1,
+		queryFn: func() (float64, error) { return 0, nil }}
+	if err := Register(m); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("want ErrNotStarted, got %v", err)
+	}
+}
+
+func TestRegister_stopsOnAutoPprofStop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var after atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, _ report.ReportInfo) error {
+			after.Add(1)
+			return nil
+		})
+
+	fm := &fakeMetric{nameVal: "gone", thresholdVal: 1, intervalVal: 20 * time.Millisecond,
+		queryFn: func() (float64, error) { return 10, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		}}
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return after.Load() > 0 }, time.Second)
+
+	ap.stop()
+	snap := after.Load()
+	time.Sleep(100 * time.Millisecond)
+	if delta := after.Load() - snap; delta != 0 {
+		t.Errorf("after Stop, got %d more reports", delta)
+	}
+}
+
+func TestRegister_duplicateAndReservedNames(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	ap := newTestAp(t, mockReporter)
+	t.Cleanup(func() { ap.stop() })
+
+	newFm := func(name string) *fakeMetric {
+		return &fakeMetric{nameVal: name, thresholdVal: 1, intervalVal: 20 * time.Millisecond,
+			queryFn: func() (float64, error) { return 0, nil }}
+	}
+	if err := ap.registerMetric(newFm("dup")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.registerMetric(newFm("dup")); !errors.Is(err, ErrDuplicateMetric) {
+		t.Errorf("want ErrDuplicateMetric, got %v", err)
+	}
+	if err := ap.registerMetric(newFm(MetricNameMem)); !errors.Is(err, ErrReservedMetricName) {
+		t.Errorf("want ErrReservedMetricName, got %v", err)
+	}
+}
+
+// -------------------------------------------------------------------
+// Unregister
+// -------------------------------------------------------------------
+
+func TestUnregister_stopsOnlyThatWatcher(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var goneCnt, keptCnt atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			switch info.MetricName {
+			case "gone":
+				goneCnt.Add(1)
+			case "kept":
+				keptCnt.Add(1)
+			}
+			return nil
+		})
+
+	newFm := func(name string) *fakeMetric {
+		return &fakeMetric{nameVal: name, thresholdVal: 1, intervalVal: 10 * time.Millisecond,
+			queryFn: func() (float64, error) { return 10, nil },
+			collectFn: func(float64) (CollectResult, error) {
+				return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+			}}
+	}
+	gone, kept := newFm("gone"), newFm("kept")
+	ap := newTestAp(t, mockReporter)
+	ap.minConsecutiveOverThreshold = 1
+	for _, m := range []Metric{gone, kept} {
+		if err := ap.registerMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { ap.stop() })
+	waitFor(t, func() bool { return goneCnt.Load() > 0 && keptCnt.Load() > 0 }, time.Second)
+
+	if err := ap.unregisterMetric("gone"); err != nil {
+		t.Fatalf("unregisterMetric() = %v", err)
+	}
+	// Unregister waits for the watcher, so no Query can follow it.
+	snap := gone.queryCalls.Load()
+	keptSnap := keptCnt.Load()
+	time.Sleep(60 * time.Millisecond)
+	if delta := gone.queryCalls.Load() - snap; delta != 0 {
+		t.Errorf("after Unregister, got %d more queries", delta)
+	}
+	if keptCnt.Load() == keptSnap {
+		t.Error("unregistering one metric stopped the other watcher")
+	}
+
+	// The name is free again.
+	if err := ap.registerMetric(newFm("gone")); err != nil {
+		t.Errorf("re-register after Unregister = %v", err)
+	}
+}
+
+func TestUnregister_waitsForInflightCollect(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	entered := make(chan struct{})
+	var finished atomic.Bool
+	fm := &fakeMetric{nameVal: "slow", thresholdVal: 1, intervalVal: 10 * time.Millisecond,
+		queryFn: func() (float64, error) { return 10, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			select {
+			case entered <- struct{}{}:
+			default:
+			}
+			time.Sleep(50 * time.Millisecond)
+			finished.Store(true)
+			return CollectResult{}, nil
+		}}
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	<-entered
+	if err := ap.unregisterMetric("slow"); err != nil {
+		t.Fatal(err)
+	}
+	if !finished.Load() {
+		t.Error("Unregister returned before the in-flight Collect finished")
+	}
+}
+
+func TestUnregister_cascadeTarget(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var reports atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(context.Context, io.Reader, report.ReportInfo) error {
+			reports.Add(1)
+			return nil
+		})
+
+	trigEntered, trigRelease := make(chan struct{}), make(chan struct{})
+	poolEntered := make(chan struct{})
+	var poolFinished atomic.Bool
+	trig := &cascadeMetric{
+		fakeMetric: &fakeMetric{nameVal: "trig", thresholdVal: 1, intervalVal: 10 * time.Millisecond,
+			queryFn: func() (float64, error) { return 10, nil },
+			collectFn: func(float64) (CollectResult, error) {
+				trigEntered <- struct{}{}
+				<-trigRelease
+				return CollectResult{Reader: strings.NewReader("t")}, nil
+			}},
+		groups: []string{"tenant"},
+	}
+	newPool := func(name string, slow bool) *cascadeMetric {
+		return &cascadeMetric{
+			fakeMetric: &fakeMetric{nameVal: name, thresholdVal: 100, intervalVal: time.Hour,
+				queryFn: func() (float64, error) { return 1, nil },
+				collectFn: func(float64) (CollectResult, error) {
+					if slow {
+						poolEntered <- struct{}{}
+						time.Sleep(50 * time.Millisecond)
+						poolFinished.Store(true)
+					}
+					return CollectResult{Reader: strings.NewReader("p")}, nil
+				}},
+			groups: []string{"tenant"},
+		}
+	}
+	gone, slow := newPool("gone", false), newPool("slow", true)
+	ap := newTestAp(t, mockReporter)
+	ap.minConsecutiveOverThreshold = 1000
+	ap.cascade = CascadePolicy{Groups: []CascadeGroup{}}
+	for _, m := range []Metric{trig, gone, slow} {
+		if err := ap.registerMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	// trig's watcher has snapshotted its cascade targets by now.
+	<-trigEntered
+	if err := ap.unregisterMetric("gone"); err != nil {
+		t.Fatal(err)
+	}
+	close(trigRelease)
+
+	<-poolEntered
+	if err := ap.unregisterMetric("slow"); err != nil {
+		t.Fatal(err)
+	}
+	if !poolFinished.Load() {
+		t.Error("Unregister returned before the in-flight cascade Collect finished")
+	}
+	waitFor(t, func() bool { return reports.Load() == 2 }, time.Second)
+	if n := gone.collectCalls.Load(); n != 0 {
+		t.Errorf("the unregistered cascade target was collected %d times", n)
+	}
+}
+
+func TestUnregister_errors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	ap := newTestAp(t, mockReporter)
+	t.Cleanup(func() { ap.stop() })
+
+	if err := ap.unregisterMetric("missing"); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("want ErrMetricNotFound, got %v", err)
+	}
+	for _, name := range []string{MetricNameCPU, MetricNameMem, MetricNameGoroutine} {
+		if err := ap.unregisterMetric(name); !errors.Is(err, ErrUnregisterBuiltIn) {
+			t.Errorf("%s: want ErrUnregisterBuiltIn, got %v", name, err)
+		}
+	}
+
+	resetGlobal()
+	if err := Unregister("x"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("want ErrNotStarted, got %v", err)
+	}
+}
+
+// -------------------------------------------------------------------
+// Profiler instances
+// -------------------------------------------------------------------
+
+func TestNew_invalidOption(t *testing.T) {
+	if _, err := New(Option{}); !errors.Is(err, ErrNilReporter) {
+		t.Errorf("want ErrNilReporter, got %v", err)
+	}
+}
+
+func TestProfiler_notStarted(t *testing.T) {
+	p, err := New(Option{Reporter: report.NewMockReporter(gomock.NewController(t))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Stop() // no-op before Start
+	m := &fakeMetric{nameVal: "x", thresholdVal: 1}
+	if err := p.Register(m); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("Register: want ErrNotStarted, got %v", err)
+	}
+	if err := p.Unregister("x"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("Unregister: want ErrNotStarted, got %v", err)
+	}
+}
+
+func TestProfiler_reconfigureValidatesAppliedFields(t *testing.T) {
+	reporter := report.NewMockReporter(gomock.NewController(t))
+	p, err := New(Option{Reporter: reporter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reconfigure(Option{CPUThreshold: 0.9}); err != nil {
+		t.Errorf("Reconfigure without a Reporter = %v", err)
+	}
+	if err := p.Reconfigure(Option{CPUThreshold: 1.5}); !errors.Is(err, ErrInvalidCPUThreshold) {
+		t.Errorf("want ErrInvalidCPUThreshold, got %v", err)
+	}
+	disableAll := Option{DisableCPUProf: true, DisableMemProf: true, DisableGoroutineProf: true}
+	if err := p.Reconfigure(disableAll); !errors.Is(err, ErrDisableAllProfiling) {
+		t.Errorf("want ErrDisableAllProfiling, got %v", err)
+	}
+
+	// The custom metrics of New keep the Profiler busy.
+	m := &fakeMetric{nameVal: "x", thresholdVal: 1, intervalVal: time.Second}
+	p, err = New(Option{Reporter: reporter, Metrics: []Metric{m}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reconfigure(disableAll); err != nil {
+		t.Errorf("disabling every built-in with Option.Metrics = %v", err)
+	}
+}
+
+func TestProfiler_independentRestartableInstances(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var mu sync.Mutex
+	seen := map[string]int{}
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			mu.Lock()
+			seen[info.MetricName]++
+			mu.Unlock()
+			return nil
+		})
+	count := func(name string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen[name]
+	}
+
+	newOpt := func(name string) Option {
+		return Option{
+			DisableCPUProf: true, DisableMemProf: true, DisableGoroutineProf: true,
+			Reporter: mockReporter,
+			Metrics: []Metric{&fakeMetric{nameVal: name, thresholdVal: 1, intervalVal: 10 * time.Millisecond,
+				queryFn: func() (float64, error) { return 10, nil },
+				collectFn: func(float64) (CollectResult, error) {
+					return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+				}}},
+		}
+	}
+	p1, err := New(newOpt("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := New(newOpt("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*Profiler{p1, p2} {
+		if err := p.Start(); err != nil {
+			t.Fatalf("Start() = %v", err)
+		}
+	}
+	t.Cleanup(func() { p1.Stop(); p2.Stop() })
+	if err := p1.Start(); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("second Start: want ErrAlreadyStarted, got %v", err)
+	}
+	waitFor(t, func() bool { return count("one") > 0 && count("two") > 0 }, time.Second)
+
+	// Stopping one instance leaves the other running.
+	p1.Stop()
+	snap := count("two")
+	waitFor(t, func() bool { return count("two") > snap }, time.Second)
+
+	// A stopped Profiler can be started again.
+	before := count("one")
+	if err := p1.Start(); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	waitFor(t, func() bool { return count("one") > before }, time.Second)
+}
+
+// -------------------------------------------------------------------
+// Hot reconfiguration
+// -------------------------------------------------------------------
+
+func TestUpdate_thresholdAndInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var reported atomic.Int32
+	var gotInfo atomic.Value
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			gotInfo.Store(info)
+			reported.Add(1)
+			return nil
+		})
+
+	fm := &fakeMetric{nameVal: "tune", thresholdVal: 100, intervalVal: time.Hour,
+		queryFn: func() (float64, error) { return 50, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		}}
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	// The hour-long ticker must not delay the new interval.
+	if err := ap.updateMetric("tune", MetricConfig{Threshold: 10, Interval: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return reported.Load() > 0 }, time.Second)
+	if info := gotInfo.Load().(report.ReportInfo); info.Threshold != 10 {
+		t.Errorf("Threshold = %v, want 10", info.Threshold)
+	}
+	// Zero debounce falls back to the instance default.
+	if cfg := ap.runners["tune"].config(); cfg.MinConsecutiveOverThreshold != ap.minConsecutiveOverThreshold {
+		t.Errorf("MinConsecutiveOverThreshold = %d, want %d",
+			cfg.MinConsecutiveOverThreshold, ap.minConsecutiveOverThreshold)
+	}
+
+	if err := ap.updateMetric("tune", MetricConfig{Threshold: 10, Interval: 10 * time.Millisecond, Disabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond) // let an in-flight tick drain
+	snap := fm.queryCalls.Load()
+	time.Sleep(60 * time.Millisecond)
+	if delta := fm.queryCalls.Load() - snap; delta != 0 {
+		t.Errorf("disabled metric was queried %d times", delta)
+	}
+}
+
+func TestUpdate_builtinZeroThreshold(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().MemUsage().AnyTimes().Return(0.1, nil)
+	mockRT := queryer.NewMockRuntimeQueryer(ctrl)
+	mockRT.EXPECT().GoroutineCount().AnyTimes().Return(1)
+	mockProf := NewMockprofiler(ctrl)
+	ap := newTestAp(t, report.NewMockReporter(ctrl))
+	ap.registerBuiltIn(&memMetric{threshold: 0.95, cg: mockCG, p: mockProf})
+	ap.registerBuiltIn(&goroutineMetric{threshold: 10, rt: mockRT, p: mockProf})
+	t.Cleanup(func() { ap.stop() })
+
+	// Zero means the default, not "fire on every tick".
+	if err := ap.updateMetric(MetricNameMem, MetricConfig{ReArmThreshold: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.updateMetric(MetricNameGoroutine, MetricConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	st := ap.status()
+	if st[0].Name != MetricNameGoroutine || st[0].Threshold != defaultGoroutineThreshold {
+		t.Errorf("goroutine Threshold = %v, want the default", st[0].Threshold)
+	}
+	if st[1].Name != MetricNameMem || st[1].Threshold != defaultMemThreshold || st[1].ReArmThreshold != 0.5 {
+		t.Errorf("mem Threshold = %v, ReArmThreshold = %v, want the default and 0.5", st[1].Threshold, st[1].ReArmThreshold)
+	}
+}
+
+func TestUpdate_errors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	ap := newTestAp(t, mockReporter)
+	t.Cleanup(func() { ap.stop() })
+
+	if err := ap.updateMetric("missing", MetricConfig{Threshold: 1}); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("want ErrMetricNotFound, got %v", err)
+	}
+	if err := ap.updateMetric("x", MetricConfig{Interval: -time.Second}); !errors.Is(err, ErrInvalidMetricConfig) {
+		t.Errorf("want ErrInvalidMetricConfig, got %v", err)
+	}
+	if err := ap.updateMetric(MetricNameCPU, MetricConfig{Threshold: 1.5}); !errors.Is(err, ErrInvalidCPUThreshold) {
+		t.Errorf("want ErrInvalidCPUThreshold, got %v", err)
+	}
+	if err := ap.updateMetric(MetricNameCPU, MetricConfig{Threshold: 0.5, Direction: DirectionBelow}); !errors.Is(err, ErrInvalidMetricConfig) {
+		t.Errorf("built-ins only breach above, got %v", err)
+	}
+	if err := ap.updateMetric(MetricNameCPU, MetricConfig{Threshold: 0.5, Interval: 2 * ap.watchInterval}); !errors.Is(err, ErrInvalidMetricConfig) {
+		t.Errorf("the cpu interval is fixed by CPUUsageWindow, got %v", err)
+	}
+	below := MetricConfig{Threshold: 0.5, Direction: DirectionBelow, ReArmThreshold: 0.4}
+	if err := ap.updateMetric("x", below); !errors.Is(err, ErrInvalidMetricConfig) {
+		t.Errorf("a below re-arm threshold must not be under the threshold, got %v", err)
+	}
+}
+
+func TestReconfigure_noCPUQuota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	errNoQuota := errors.New("quota undefined")
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().SetCPUQuota().AnyTimes().Return(errNoQuota)
+	mockCG.EXPECT().MemUsage().AnyTimes().Return(0.1, nil)
+	mockProf := NewMockprofiler(ctrl)
+
+	logger := &recordLogger{}
+	ap := newTestAp(t, report.NewMockReporter(ctrl))
+	ap.cgroupQueryer = mockCG
+	ap.profiler = mockProf
+	ap.logger = logger
+	ap.registerBuiltIn(&memMetric{threshold: 0.75, cg: mockCG, p: mockProf})
+	t.Cleanup(func() { ap.stop() })
+
+	// cpu stays off with a warning, like at Start; mem is retuned.
+	if err := ap.reconfigure(Option{MemThreshold: 0.5, DisableGoroutineProf: true}); err != nil {
+		t.Fatalf("reconfigure() = %v", err)
+	}
+	if _, ok := ap.runners[MetricNameCPU]; ok {
+		t.Error("cpu started without a CPU quota")
+	}
+	if cfg := ap.runners[MetricNameMem].config(); cfg.Threshold != 0.5 {
+		t.Errorf("mem Threshold = %v, want 0.5", cfg.Threshold)
+	}
+	records := logger.snapshot()
+	if len(records) != 1 || records[0].level != "WARN" || records[0].fields[logKeyError] != errNoQuota {
+		t.Errorf("unexpected log records: %+v", records)
+	}
+
+	// With mem disabled too there is nothing left to profile.
+	err := ap.reconfigure(Option{DisableMemProf: true, DisableGoroutineProf: true})
+	if !errors.Is(err, errNoQuota) {
+		t.Errorf("want the quota error, got %v", err)
+	}
+}
+
+func TestReconfigure_builtinThresholdAndDisable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().MemUsage().AnyTimes().Return(0.9, nil)
+	mockRT := queryer.NewMockRuntimeQueryer(ctrl)
+	mockRT.EXPECT().GoroutineCount().AnyTimes().Return(1)
+	mockProf := NewMockprofiler(ctrl)
+	mockProf.EXPECT().profileHeap().AnyTimes().Return([]byte("h"), nil)
+	mockProf.EXPECT().profileGoroutine().AnyTimes().Return([]byte("g"), nil)
+
+	var gotInfo atomic.Value
+	var reported atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			if info.MetricName == MetricNameMem {
+				gotInfo.Store(info)
+				reported.Add(1)
+			}
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.cgroupQueryer = mockCG
+	ap.runtimeQueryer = mockRT
+	ap.profiler = mockProf
+	ap.registerBuiltIn(&memMetric{threshold: 0.95, cg: mockCG, p: mockProf})
+	t.Cleanup(func() { ap.stop() })
+
+	time.Sleep(60 * time.Millisecond)
+	if n := reported.Load(); n != 0 {
+		t.Fatalf("mem below threshold reported %d times", n)
+	}
+
+	// Lower the mem threshold and enable goroutine, which wasn't started.
+	err := ap.reconfigure(Option{DisableCPUProf: true, MemThreshold: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return reported.Load() > 0 }, time.Second)
+	info := gotInfo.Load().(report.ReportInfo)
+	if info.Threshold != 0.5 || !strings.Contains(info.Comment, "50.00%") {
+		t.Errorf("Threshold=%v Comment=%q, want the reconfigured 0.5", info.Threshold, info.Comment)
+	}
+	if _, ok := ap.runners[MetricNameGoroutine]; !ok {
+		t.Error("enabled goroutine built-in was not started")
+	}
+
+	if err := ap.reconfigure(Option{DisableCPUProf: true, DisableMemProf: true}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := ap.runners[MetricNameMem].config(); !cfg.Disabled {
+		t.Error("DisableMemProf did not pause the mem runner")
+	}
+}
+
+func TestUpdate_restartsFailedWatcher(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var memCalls atomic.Int32
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().MemUsage().AnyTimes().DoAndReturn(func() (float64, error) {
+		if memCalls.Add(1) == 1 {
+			return 0, errors.New("EBUSY")
+		}
+		return 0.1, nil
+	})
+	mockProf := NewMockprofiler(ctrl)
+	mockReporter := report.NewMockReporter(ctrl)
+
+	var failing atomic.Bool
+	failing.Store(true)
+	fm := &fakeMetric{nameVal: "flaky", thresholdVal: 100, intervalVal: 5 * time.Millisecond,
+		queryFn: func() (float64, error) {
+			if failing.Load() {
+				return 0, errors.New("down")
+			}
+			return 1, nil
+		}}
+	ap := newTestAp(t, mockReporter)
+	ap.cgroupQueryer = mockCG
+	ap.profiler = mockProf
+	ap.watchInterval = 5 * time.Millisecond
+	if err := ap.registerBuiltIn(&memMetric{threshold: 0.95, cg: mockCG, p: mockProf}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+	waitFor(t, func() bool { return len(ap.failedMetrics()) == 2 }, time.Second)
+
+	failing.Store(false)
+	if err := ap.updateMetric("flaky", MetricConfig{Threshold: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.reconfigure(Option{DisableCPUProf: true, DisableGoroutineProf: true, MemThreshold: 0.9}); err != nil {
+		t.Fatal(err)
+	}
+	if failed := ap.failedMetrics(); len(failed) != 0 {
+		t.Errorf("failedMetrics() = %v, want none", failed)
+	}
+	queries := fm.queryCalls.Load()
+	waitFor(t, func() bool { return fm.queryCalls.Load() > queries && memCalls.Load() > 2 }, time.Second)
+	for _, st := range ap.status() {
+		if !st.Watching || st.LastError == nil {
+			t.Errorf("%s: Watching = %v, LastError = %v; want a watched metric keeping its last error",
+				st.Name, st.Watching, st.LastError)
+		}
+	}
+	if st := ap.status(); st[1].Name != "mem" || st[1].Threshold != 0.9 || st[0].Threshold != 50 {
+		t.Errorf("unexpected Status: %+v", st)
+	}
+}
+
+// -------------------------------------------------------------------
+// Lifecycle hooks
+// -------------------------------------------------------------------
+
+// hookRecorder collects hook invocations by name.
+type hookRecorder struct {
+	mu     sync.Mutex
+	events map[string][]Event
+}
+
+func (h *hookRecorder) hooks() Hooks {
+	rec := func(name string) func(Event) {
+		return func(e Event) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if h.events == nil {
+				h.events = make(map[string][]Event)
+			}
+			h.events[name] = append(h.events[name], e)
+		}
+	}
+	return Hooks{
+		OnBreach:       rec("breach"),
+		OnDebounced:    rec("debounced"),
+		OnCollectStart: rec("collectStart"),
+		OnCollectEnd:   rec("collectEnd"),
+		OnReport:       rec("report"),
+		OnCascade:      rec("cascade"),
+		OnWatcherExit:  rec("exit"),
+		OnError:        rec("error"),
 	}
+}
+
+func (h *hookRecorder) get(name string) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Event(nil), h.events[name]...)
+}
+
+func TestHooks_customMetricLifecycle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	errUpload := errors.New("upload failed")
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().Return(errUpload)
+
+	rec := &hookRecorder{}
 	ap := newTestAp(t, mockReporter)
+	ap.hooks = rec.hooks()
+	ap.logger = &recordLogger{}
+	ap.minConsecutiveOverThreshold = 3
+	fm := &fakeMetric{nameVal: "hooked", thresholdVal: 5, intervalVal: 10 * time.Millisecond,
+		queryFn: func() (float64, error) { return 9, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("12345")), Filename: "h.bin"}, nil
+		}}
 	if err := ap.registerMetric(fm); err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { ap.stop() })
-	time.Sleep(80 * time.Millisecond)
-	// Test passes if the mock's Times(0) expectation is satisfied by gomock.
+
+	waitFor(t, func() bool { return len(rec.get("debounced")) >= 2 }, time.Second)
+	if err := ap.unregisterMetric("hooked"); err != nil {
+		t.Fatal(err)
+	}
+
+	breach := rec.get("breach")[0]
+	if breach.MetricName != "hooked" || breach.Value != 9 || breach.Threshold != 5 {
+		t.Errorf("unexpected breach event: %+v", breach)
+	}
+	if len(rec.get("collectStart")) == 0 {
+		t.Error("OnCollectStart not called")
+	}
+	end := rec.get("collectEnd")[0]
+	if end.Size != 5 || end.Info.Filename != "h.bin" || end.Err != nil {
+		t.Errorf("unexpected collect end event: %+v", end)
+	}
+	rep := rec.get("report")[0]
+	if !errors.Is(rep.Err, errUpload) || rep.Info.MetricName != "hooked" {
+		t.Errorf("unexpected report event: %+v", rep)
+	}
+	errEv := rec.get("error")[0]
+	if errEv.Phase != "report" || !errors.Is(errEv.Err, errUpload) {
+		t.Errorf("unexpected error event: %+v", errEv)
+	}
+	exits := rec.get("exit")
+	if len(exits) != 1 || exits[0].Err != nil || exits[0].MetricName != "hooked" {
+		t.Errorf("unexpected exit events: %+v", exits)
+	}
+	if n := len(rec.get("cascade")); n != 0 {
+		t.Errorf("custom metric triggered %d cascade events", n)
+	}
 }
 
-func TestUserMetric_defaultFilenameComment(t *testing.T) {
+func TestHooks_cascadeAndFailedExit(t *testing.T) {
 	ctrl := gomock.NewController(t)
-	var info report.ReportInfo
-	var done atomic.Int32
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().CPUUsage().AnyTimes().Return(0.9, nil)
+	mockProf := NewMockprofiler(ctrl)
+	mockProf.EXPECT().profileCPU().AnyTimes().Return([]byte("c"), nil)
 	mockReporter := report.NewMockReporter(ctrl)
-	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
-		AnyTimes().
-		DoAndReturn(func(_ context.Context, _ io.Reader, i report.ReportInfo) error {
-			info = i
-			done.Add(1)
-			return nil
-		})
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
 
-	fm := &fakeMetric{
-		nameVal: "defaults", thresholdVal: 1, intervalVal: 20 * time.Millisecond,
-		queryFn: func() (float64, error) { return 10, nil },
-		collectFn: func(float64) (CollectResult, error) {
-			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
-		},
-	}
+	rec := &hookRecorder{}
 	ap := newTestAp(t, mockReporter)
-	if err := ap.registerMetric(fm); err != nil {
+	ap.hooks = rec.hooks()
+	ap.logger = &recordLogger{}
+	ap.registerBuiltIn(&cpuMetric{threshold: 0.5, cg: mockCG, p: mockProf})
+	dead := &fakeMetric{nameVal: "dead", thresholdVal: 1, intervalVal: 10 * time.Millisecond,
+		queryFn: func() (float64, error) { return 0, errors.New("gone") }}
+	if err := ap.registerMetric(dead); err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { ap.stop() })
 
-	waitFor(t, func() bool { return done.Load() > 0 }, time.Second)
-	if !strings.Contains(info.Filename, "defaults.") || !strings.Contains(info.Filename, ".bin") {
-		t.Errorf("default Filename=%q not generated", info.Filename)
+	waitFor(t, func() bool {
+		return len(rec.get("cascade")) > 0 && len(rec.get("exit")) > 0
+	}, time.Second)
+	if c := rec.get("cascade")[0]; c.MetricName != "cpu" || c.Value != 0.9 {
+		t.Errorf("unexpected cascade event: %+v", c)
 	}
-	if !strings.Contains(info.Comment, "[defaults]") {
-		t.Errorf("default Comment=%q not generated", info.Comment)
+	if e := rec.get("exit")[0]; e.MetricName != "dead" || e.Err == nil {
+		t.Errorf("unexpected exit event: %+v", e)
+	}
+}
+
+func TestPayloadSize(t *testing.T) {
+	if n := payloadSize(bytes.NewReader([]byte("abc"))); n != 3 {
+		t.Errorf("bytes.Reader size = %d, want 3", n)
+	}
+	if n := payloadSize(io.MultiReader(strings.NewReader("abc"))); n != -1 {
+		t.Errorf("opaque reader size = %d, want -1", n)
+	}
+	if n := payloadSize(nil); n != 0 {
+		t.Errorf("nil reader size = %d, want 0", n)
 	}
 }
 
 // -------------------------------------------------------------------
-// Register lifecycle
+// Status
 // -------------------------------------------------------------------
 
-func TestRegister_errNotStarted(t *testing.T) {
-	resetGlobal()
-	m := &fakeMetric{nameVal: "x", thresholdVal: 1,
-		queryFn: func() (float64, error) { return 0, nil }}
-	if err := Register(m); !errors.Is(err, ErrNotStarted) {
-		t.Errorf("want ErrNotStarted, got %v", err)
+func TestStatus_reportsLiveState(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockCG := queryer.NewMockCgroupsQueryer(ctrl)
+	mockCG.EXPECT().MemUsage().AnyTimes().Return(0.2, nil)
+	mockProf := NewMockprofiler(ctrl)
+	errUpload := errors.New("upload failed")
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().Return(errUpload)
+
+	ap := newTestAp(t, mockReporter)
+	ap.logger = &recordLogger{}
+	ap.minConsecutiveOverThreshold = 1000
+	ap.registerBuiltIn(&memMetric{threshold: 0.75, cg: mockCG, p: mockProf})
+	fm := &fakeMetric{nameVal: "custom", thresholdVal: 5, intervalVal: 10 * time.Millisecond,
+		queryFn: func() (float64, error) { return 9, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		}}
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool {
+		st := ap.status()
+		return len(st) == 2 && st[0].ConsecutiveOver >= 2 && !st[1].LastQueryTime.IsZero()
+	}, time.Second)
+
+	st := ap.status()
+	custom, mem := st[0], st[1]
+	if custom.Name != "custom" || custom.BuiltIn || custom.Cascade || !custom.Watching ||
+		custom.Threshold != 5 || custom.Interval != 10*time.Millisecond || custom.LastValue != 9 {
+		t.Errorf("unexpected custom status: %+v", custom)
+	}
+	if custom.ReportsFired != 1 || !errors.Is(custom.LastReportError, errUpload) ||
+		!errors.Is(custom.LastError, errUpload) || custom.LastReportTime.IsZero() {
+		t.Errorf("unexpected custom report state: %+v", custom)
+	}
+	if mem.Name != "mem" || !mem.BuiltIn || !mem.Cascade || mem.LastValue != 0.2 ||
+		mem.ConsecutiveOver != 0 || mem.ReportsFired != 0 || mem.Interval != ap.watchInterval {
+		t.Errorf("unexpected mem status: %+v", mem)
 	}
 }
 
-func TestRegister_stopsOnAutoPprofStop(t *testing.T) {
+// -------------------------------------------------------------------
+// On-demand capture
+// -------------------------------------------------------------------
+
+func TestCapture_belowThreshold(t *testing.T) {
 	ctrl := gomock.NewController(t)
-	var after atomic.Int32
+	var reported atomic.Int32
 	mockReporter := report.NewMockReporter(ctrl)
 	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
 		AnyTimes().
 		DoAndReturn(func(_ context.Context, _ io.Reader, _ report.ReportInfo) error {
-			after.Add(1)
+			reported.Add(1)
 			return nil
 		})
 
-	fm := &fakeMetric{nameVal: "gone", thresholdVal: 1, intervalVal: 20 * time.Millisecond,
-		queryFn: func() (float64, error) { return 10, nil },
+	fm := &fakeMetric{nameVal: "manual", thresholdVal: 100, intervalVal: time.Hour,
+		queryFn: func() (float64, error) { return 7, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x")), Filename: "m.txt"}, nil
+		}}
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	info, err := ap.capture(context.Background(), "manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MetricName != "manual" || info.Value != 7 || info.Threshold != 100 ||
+		info.Filename != "m.txt" || !strings.Contains(info.Comment, "[manual]") {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if reported.Load() != 1 {
+		t.Errorf("reported %d times, want 1", reported.Load())
+	}
+
+	if _, err := ap.capture(context.Background(), "missing"); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("want ErrMetricNotFound, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ap.capture(ctx, "manual"); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestCaptureAll_aggregatesErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	errBroken := errors.New("broken")
+	ok := &fakeMetric{nameVal: "a_ok", thresholdVal: 100, intervalVal: time.Hour,
+		queryFn: func() (float64, error) { return 1, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		}}
+	broken := &fakeMetric{nameVal: "b_broken", thresholdVal: 100, intervalVal: time.Hour,
+		queryFn:   func() (float64, error) { return 1, nil },
+		collectFn: func(float64) (CollectResult, error) { return CollectResult{}, errBroken }}
+	ap := newTestAp(t, mockReporter)
+	for _, m := range []Metric{broken, ok} {
+		if err := ap.registerMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	infos, err := ap.captureAll(context.Background())
+	if len(infos) != 1 || infos[0].MetricName != "a_ok" {
+		t.Errorf("infos = %+v, want only a_ok", infos)
+	}
+	if err == nil || !strings.Contains(err.Error(), "b_broken") || !errors.Is(err, errBroken) {
+		t.Errorf("err = %v, want b_broken failure", err)
+	}
+}
+
+func TestCapture_canceledDuringCollect(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fm := &fakeMetric{nameVal: "slow", thresholdVal: 100, intervalVal: time.Hour,
+		queryFn: func() (float64, error) { return 1, nil },
 		collectFn: func(float64) (CollectResult, error) {
+			cancel()
 			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
 		}}
 	ap := newTestAp(t, mockReporter)
 	if err := ap.registerMetric(fm); err != nil {
 		t.Fatal(err)
 	}
-	waitFor(t, func() bool { return after.Load() > 0 }, time.Second)
+	t.Cleanup(func() { ap.stop() })
 
-	ap.stop()
-	snap := after.Load()
-	time.Sleep(100 * time.Millisecond)
-	if delta := after.Load() - snap; delta != 0 {
-		t.Errorf("after Stop, got %d more reports", delta)
+	if _, err := ap.capture(ctx, "slow"); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestCapture_unregistered(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	entered := make(chan struct{})
+	var finished atomic.Bool
+	fm := &fakeMetric{nameVal: "pool", thresholdVal: 100, intervalVal: time.Hour,
+		queryFn: func() (float64, error) { return 1, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			close(entered)
+			time.Sleep(50 * time.Millisecond)
+			finished.Store(true)
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		}}
+	ap := newTestAp(t, mockReporter)
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+	ap.mu.Lock()
+	runner := ap.runners["pool"]
+	ap.mu.Unlock()
+
+	go ap.capture(context.Background(), "pool")
+	<-entered
+	if err := ap.unregisterMetric("pool"); err != nil {
+		t.Fatal(err)
+	}
+	if !finished.Load() {
+		t.Error("Unregister returned before the in-flight Capture finished")
+	}
+
+	// A capture that looked the runner up before Unregister skips it.
+	if _, err := ap.captureRunner(context.Background(), runner, newIncident("")); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("want ErrMetricNotFound, got %v", err)
+	}
+	if n := fm.collectCalls.Load(); n != 1 {
+		t.Errorf("Collect called %d times, want 1", n)
+	}
+}
+
+// unsyncMetric mutates plain fields in Query/Collect; -race flags it
+// if autopprof ever calls them concurrently.
+type unsyncMetric struct {
+	queries, collects int
+}
+
+func (m *unsyncMetric) Name() string            { return "unsync" }
+func (m *unsyncMetric) Threshold() float64      { return 1 }
+func (m *unsyncMetric) Interval() time.Duration { return time.Millisecond }
+func (m *unsyncMetric) Query() (float64, error) {
+	m.queries++
+	return 10, nil
+}
+func (m *unsyncMetric) Collect(float64) (CollectResult, error) {
+	m.collects++
+	return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+}
+
+func TestCapture_serializedWithWatcher(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	ap := newTestAp(t, mockReporter)
+	ap.minConsecutiveOverThreshold = 1
+	if err := ap.registerMetric(&unsyncMetric{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	for i := 0; i < 20; i++ {
+		if _, err := ap.capture(context.Background(), "unsync"); err != nil {
+			t.Fatal(err)
+		}
 	}
 }
 
 // -------------------------------------------------------------------
-// Query error terminates the watcher
+// Query errors: retry policy and watcher exit
 // -------------------------------------------------------------------
 
 func TestWatchMetric_queryErrorExitsWatcher(t *testing.T) {
@@ -593,12 +2471,134 @@ func TestWatchMetric_queryErrorExitsWatcher(t *testing.T) {
 	// Give the ticker one or two fires then Stop should return promptly,
 	// proving the watcher exited on its own.
 	time.Sleep(60 * time.Millisecond)
+	if err := ap.failedMetrics()["boom"]; err == nil || err.Error() != "boom" {
+		t.Errorf("failedMetrics()[boom] = %v, want boom", err)
+	}
 	ap.stop()
 	if n := broken.queryCalls.Load(); n < 1 {
 		t.Errorf("expected at least one Query call, got %d", n)
 	}
 }
 
+func TestWatchMetric_structuredLogs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	errUpload := errors.New("upload failed")
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().Return(errUpload)
+
+	logger := &recordLogger{}
+	ap := newTestAp(t, mockReporter)
+	ap.logger = logger
+	fm := &fakeMetric{nameVal: "logged", thresholdVal: 5, intervalVal: 10 * time.Millisecond,
+		queryFn: func() (float64, error) { return 9, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		}}
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool { return len(logger.snapshot()) > 0 }, time.Second)
+	rec := logger.snapshot()[0]
+	if rec.level != "ERROR" || rec.fields["metric"] != "logged" ||
+		rec.fields["phase"] != "report" || rec.fields["value"] != 9.0 ||
+		rec.fields["threshold"] != 5.0 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if err, _ := rec.fields["error"].(error); !errors.Is(err, errUpload) {
+		t.Errorf("error field = %v, want %v", rec.fields["error"], errUpload)
+	}
+}
+
+func TestQueryErrorPolicy_withDefaults(t *testing.T) {
+	p := QueryErrorPolicy{}.withDefaults()
+	if p.MaxConsecutiveErrors != 5 || p.InitialBackoff != time.Second || p.MaxBackoff != time.Minute {
+		t.Errorf("zero policy = %+v, want 5 errors with 1s..1m backoff", p)
+	}
+	if p := (QueryErrorPolicy{MaxConsecutiveErrors: 1}).withDefaults(); p.MaxConsecutiveErrors != 1 {
+		t.Errorf("MaxConsecutiveErrors = %d, want 1 kept", p.MaxConsecutiveErrors)
+	}
+}
+
+func TestWatchMetric_queryRetryRecovers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	var reported atomic.Int32
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, _ report.ReportInfo) error {
+			reported.Add(1)
+			return nil
+		})
+
+	var hookCalls []int
+	var mu sync.Mutex
+	ap := newTestAp(t, mockReporter)
+	ap.queryErrorPolicy = QueryErrorPolicy{
+		MaxConsecutiveErrors: 5,
+		InitialBackoff:       time.Millisecond,
+		MaxBackoff:           2 * time.Millisecond,
+		OnQueryError: func(metric string, err error, consecutive int) {
+			mu.Lock()
+			hookCalls = append(hookCalls, consecutive)
+			mu.Unlock()
+		},
+	}
+
+	var calls atomic.Int32
+	flaky := &fakeMetric{nameVal: "flaky", thresholdVal: 1, intervalVal: 20 * time.Millisecond,
+		queryFn: func() (float64, error) {
+			if calls.Add(1) <= 2 {
+				return 0, errors.New("EBUSY")
+			}
+			return 10, nil
+		},
+		collectFn: func(float64) (CollectResult, error) {
+			return CollectResult{Reader: bytes.NewReader([]byte("x"))}, nil
+		}}
+	if err := ap.registerMetric(flaky); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool { return reported.Load() > 0 }, time.Second)
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(hookCalls) != "[1 2]" {
+		t.Errorf("OnQueryError consecutive = %v, want [1 2]", hookCalls)
+	}
+	if failed := ap.failedMetrics(); len(failed) != 0 {
+		t.Errorf("failedMetrics() = %v, want none", failed)
+	}
+}
+
+func TestWatchMetric_queryErrorBudgetExhausted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockReporter := report.NewMockReporter(ctrl)
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	ap := newTestAp(t, mockReporter)
+	ap.queryErrorPolicy = QueryErrorPolicy{
+		MaxConsecutiveErrors: 3,
+		InitialBackoff:       time.Millisecond,
+		MaxBackoff:           time.Millisecond,
+	}
+	dead := &fakeMetric{nameVal: "dead", thresholdVal: 1, intervalVal: 10 * time.Millisecond,
+		queryFn: func() (float64, error) { return 0, errors.New("gone") }}
+	if err := ap.registerMetric(dead); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool { return ap.failedMetrics()["dead"] != nil }, time.Second)
+	time.Sleep(40 * time.Millisecond)
+	if n := dead.queryCalls.Load(); n != 3 {
+		t.Errorf("Query called %d times, want 3", n)
+	}
+}
+
 // -------------------------------------------------------------------
 // Stop idempotency
 // -------------------------------------------------------------------
@@ -3,6 +3,75 @@
 
 package autopprof
 
+import (
+	"context"
+
+	"github.com/daangn/autopprof/v2/report"
+)
+
+// Profiler does not do anything on unsupported platforms.
+type Profiler struct{}
+
+// New does not do anything on unsupported platforms.
+func New(opt Option) (*Profiler, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// Start does not do anything on unsupported platforms.
+func (p *Profiler) Start() error {
+	return ErrUnsupportedPlatform
+}
+
+// Stop does not do anything on unsupported platforms.
+func (p *Profiler) Stop() {}
+
+// Register does not do anything on unsupported platforms.
+func (p *Profiler) Register(m Metric) error {
+	return ErrUnsupportedPlatform
+}
+
+// Unregister does not do anything on unsupported platforms.
+func (p *Profiler) Unregister(name string) error {
+	return ErrUnsupportedPlatform
+}
+
+// Update does not do anything on unsupported platforms.
+func (p *Profiler) Update(name string, cfg MetricConfig) error {
+	return ErrUnsupportedPlatform
+}
+
+// Reconfigure does not do anything on unsupported platforms.
+func (p *Profiler) Reconfigure(opt Option) error {
+	return ErrUnsupportedPlatform
+}
+
+// Capture does not do anything on unsupported platforms.
+func (p *Profiler) Capture(
+	ctx context.Context, name string,
+) (report.ReportInfo, error) {
+	return report.ReportInfo{}, ErrUnsupportedPlatform
+}
+
+// CaptureAll does not do anything on unsupported platforms.
+func (p *Profiler) CaptureAll(ctx context.Context) ([]report.ReportInfo, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// FailedMetrics does not do anything on unsupported platforms.
+func (p *Profiler) FailedMetrics() map[string]error {
+	return nil
+}
+
+// Status does not do anything on unsupported platforms.
+func (p *Profiler) Status() []MetricStatus {
+	return nil
+}
+
+// ReplaySpool does not do anything on unsupported platforms.
+func (p *Profiler) ReplaySpool(ctx context.Context) (int, error) {
+	return 0, ErrUnsupportedPlatform
+}
+
 // Start does not do anything on unsupported platforms.
 func Start(opt Option) error {
 	return ErrUnsupportedPlatform
@@ -15,3 +84,43 @@ func Stop() {}
 func Register(m Metric) error {
 	return ErrUnsupportedPlatform
 }
+
+// Unregister does not do anything on unsupported platforms.
+func Unregister(name string) error {
+	return ErrUnsupportedPlatform
+}
+
+// Update does not do anything on unsupported platforms.
+func Update(name string, cfg MetricConfig) error {
+	return ErrUnsupportedPlatform
+}
+
+// Reconfigure does not do anything on unsupported platforms.
+func Reconfigure(opt Option) error {
+	return ErrUnsupportedPlatform
+}
+
+// Capture does not do anything on unsupported platforms.
+func Capture(ctx context.Context, name string) (report.ReportInfo, error) {
+	return report.ReportInfo{}, ErrUnsupportedPlatform
+}
+
+// CaptureAll does not do anything on unsupported platforms.
+func CaptureAll(ctx context.Context) ([]report.ReportInfo, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// FailedMetrics does not do anything on unsupported platforms.
+func FailedMetrics() map[string]error {
+	return nil
+}
+
+// Status does not do anything on unsupported platforms.
+func Status() []MetricStatus {
+	return nil
+}
+
+// ReplaySpool does not do anything on unsupported platforms.
+func ReplaySpool(ctx context.Context) (int, error) {
+	return 0, ErrUnsupportedPlatform
+}
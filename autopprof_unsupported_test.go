@@ -38,3 +38,15 @@ func TestRegister_unsupportedPlatform(t *testing.T) {
 		t.Errorf("Register() = %v, want %v", err, ErrUnsupportedPlatform)
 	}
 }
+
+func TestUnregister_unsupportedPlatform(t *testing.T) {
+	if err := Unregister("x"); !errors.Is(err, ErrUnsupportedPlatform) {
+		t.Errorf("Unregister() = %v, want %v", err, ErrUnsupportedPlatform)
+	}
+}
+
+func TestNew_unsupportedPlatform(t *testing.T) {
+	if _, err := New(Option{}); !errors.Is(err, ErrUnsupportedPlatform) {
+		t.Errorf("New() = %v, want %v", err, ErrUnsupportedPlatform)
+	}
+}
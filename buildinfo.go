@@ -0,0 +1,44 @@
+package autopprof
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	// GoVersion is the Go toolchain that built the binary.
+	GoVersion string
+	// Path and Version are the main module's path and version
+	// ("(devel)" for a local build).
+	Path    string
+	Version string
+	// Revision is the VCS revision the binary was built from, empty
+	// when built without VCS stamping.
+	Revision string
+}
+
+var (
+	buildInfoOnce sync.Once
+	buildInfo     BuildInfo
+)
+
+// currentBuildInfo reads the build info once per process.
+func currentBuildInfo() BuildInfo {
+	buildInfoOnce.Do(func() {
+		buildInfo.GoVersion = runtime.Version()
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		buildInfo.Path = bi.Main.Path
+		buildInfo.Version = bi.Main.Version
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				buildInfo.Revision = s.Value
+			}
+		}
+	})
+	return buildInfo
+}
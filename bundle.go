@@ -0,0 +1,210 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/daangn/autopprof/v2/report"
+)
+
+const bundleManifestName = "manifest.json"
+
+// bundleManifest is the manifest.json of a bundle.
+type bundleManifest struct {
+	IncidentID  string       `json:"incident_id"`
+	TriggeredBy string       `json:"triggered_by"`
+	Files       []bundleFile `json:"files"`
+}
+
+type bundleFile struct {
+	Name string            `json:"name"`
+	Info report.ReportInfo `json:"info"`
+}
+
+// bundleFrom runs the cascade of inc like cascadeFrom, sending
+// triggered's payload and its targets' as one bundle. triggered is
+// collected on its watcher, so Unregister and the next tick wait for
+// its Collect; only the targets are collected on the cascade goroutine.
+func (ap *autoPprof) bundleFrom(
+	triggered *metricRunner, cfg MetricConfig, value float64, inc incident, targets []*metricRunner,
+) {
+	jobs := make([]reportJob, 0, 1+len(targets))
+	job, err := ap.collectJob(triggered, cfg, value, inc)
+	if err != nil {
+		ap.logReportError(triggered, cfg, value, err)
+	} else if job.reader != nil {
+		jobs = append(jobs, job)
+	}
+	if ap.queue == nil {
+		ap.runBundle(triggered, cfg, value, inc, jobs, targets)
+		return
+	}
+	ap.wg.Add(1)
+	go func() {
+		defer ap.wg.Done()
+		ap.runBundle(triggered, cfg, value, inc, jobs, targets)
+	}()
+}
+
+// runBundle adds the payloads of targets to jobs, then ships them as
+// one archive. A failing collect leaves its payload out, and so does a
+// target unregistered since the cascade began; the others are held
+// until the archive is sent.
+func (ap *autoPprof) runBundle(
+	triggered *metricRunner, cfg MetricConfig, value float64, inc incident,
+	jobs []reportJob, targets []*metricRunner,
+) {
+	cascade := inc
+	cascade.cascade = true
+	for _, r := range targets {
+		if !r.acquire() {
+			continue
+		}
+		defer r.release()
+		cfg, value, ok := ap.queryCascade(r, inc.triggeredBy)
+		if !ok {
+			continue
+		}
+		job, err := ap.collectJob(r, cfg, value, cascade)
+		if err != nil {
+			ap.logCascadeError(r, inc.triggeredBy, cfg, value, err)
+			continue
+		}
+		if job.reader != nil {
+			jobs = append(jobs, job)
+		}
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	bundle, err := ap.bundleJobs(inc, jobs)
+	if err != nil {
+		ap.logReportError(triggered, cfg, value, &phaseError{phase: phaseCollect, err: err})
+		return
+	}
+	if ap.queue != nil {
+		ap.queue.enqueue(bundle)
+		return
+	}
+	if err := ap.deliver(context.Background(), bundle); err != nil {
+		ap.logReportError(triggered, cfg, value, err)
+	}
+}
+
+// bundleJobs archives the payloads of jobs into one job. Its
+// ReportInfo is the first job's, with the archive's filename, the
+// capture window of all jobs and the bundled metrics in the Comment.
+func (ap *autoPprof) bundleJobs(inc incident, jobs []reportJob) (reportJob, error) {
+	first := jobs[0]
+	info := first.info
+	manifest := bundleManifest{IncidentID: inc.id, TriggeredBy: inc.triggeredBy}
+	payloads := make([][]byte, len(jobs))
+	used := make(map[string]bool, len(jobs))
+	var others []string
+	for i, job := range jobs {
+		b, err := io.ReadAll(job.reader)
+		if err != nil {
+			return reportJob{}, fmt.Errorf("autopprof: failed to read the %s payload: %w", job.runner.name, err)
+		}
+		payloads[i] = b
+		name := strings.TrimLeft(job.info.Filename, "/")
+		if used[name] {
+			name = fmt.Sprintf("%d-%s", i, name)
+		}
+		used[name] = true
+		manifest.Files = append(manifest.Files, bundleFile{Name: name, Info: job.info})
+		if job.info.StartTime.Before(info.StartTime) {
+			info.StartTime = job.info.StartTime
+		}
+		if job.info.EndTime.After(info.EndTime) {
+			info.EndTime = job.info.EndTime
+		}
+		if i > 0 {
+			others = append(others, job.runner.name)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, ap.cascade.Bundle, manifest, payloads, info.EndTime); err != nil {
+		return reportJob{}, fmt.Errorf("autopprof: failed to write the bundle: %w", err)
+	}
+
+	info.Filename = fmt.Sprintf("pprof.%s.%s.%s.incident.%s%s",
+		ap.app, info.Hostname, inc.triggeredBy, info.EndTime.Format(reportTimeLayout), ap.cascade.Bundle.ext(),
+	)
+	if len(others) > 0 {
+		info.Comment += "\nBundled with " + strings.Join(others, ", ")
+	}
+	event := first.event
+	event.Info = info
+	event.Size = int64(buf.Len())
+	bundle := reportJob{
+		runner: first.runner,
+		reader: bytes.NewReader(buf.Bytes()),
+		info:   info,
+		event:  event,
+	}
+	for _, job := range jobs[1:] {
+		bundle.members = append(bundle.members, job.runner)
+	}
+	return bundle, nil
+}
+
+// writeBundle writes manifest.json, then each payload under the name
+// the manifest gives it, as a format archive.
+func writeBundle(
+	w io.Writer, format BundleFormat, manifest bundleManifest, payloads [][]byte, modTime time.Time,
+) error {
+	m, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	names := []string{bundleManifestName}
+	contents := [][]byte{m}
+	for i, f := range manifest.Files {
+		names = append(names, f.Name)
+		contents = append(contents, payloads[i])
+	}
+
+	if format == BundleZip {
+		zw := zip.NewWriter(w)
+		for i, name := range names {
+			fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(contents[i]); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for i, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents[i])), ModTime: modTime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(contents[i]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
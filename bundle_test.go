@@ -0,0 +1,167 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daangn/autopprof/v2/report"
+	"github.com/golang/mock/gomock"
+)
+
+// readBundle returns the files of a bundle by name.
+func readBundle(t *testing.T, format BundleFormat, data []byte) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	if format == BundleZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			files[f.Name] = string(b)
+		}
+		return files
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+}
+
+func TestCascade_bundle(t *testing.T) {
+	for _, format := range []BundleFormat{BundleTarGz, BundleZip} {
+		t.Run(format.ext(), func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				infos    []report.ReportInfo
+				payloads [][]byte
+			)
+			mockReporter := report.NewMockReporter(gomock.NewController(t))
+			mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+				AnyTimes().
+				DoAndReturn(func(_ context.Context, r io.Reader, info report.ReportInfo) error {
+					b, _ := io.ReadAll(r)
+					mu.Lock()
+					infos = append(infos, info)
+					payloads = append(payloads, b)
+					mu.Unlock()
+					return nil
+				})
+			ap := newTestAp(t, mockReporter)
+			ap.app = "web"
+			ap.cascade = CascadePolicy{Groups: []CascadeGroup{}, Bundle: format}
+			mk := func(name string, value float64, interval time.Duration) *cascadeMetric {
+				return &cascadeMetric{
+					fakeMetric: &fakeMetric{nameVal: name, thresholdVal: 10, intervalVal: interval,
+						queryFn: func() (float64, error) { return value, nil },
+						collectFn: func(float64) (CollectResult, error) {
+							return CollectResult{Reader: strings.NewReader(name + "-profile"), Filename: "same.pprof"}, nil
+						}},
+					groups: []string{"pair"},
+				}
+			}
+			for _, m := range []Metric{mk("a", 20, 10*time.Millisecond), mk("b", 0, time.Hour)} {
+				if err := ap.registerMetric(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Cleanup(func() { ap.stop() })
+
+			waitFor(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(infos) > 0
+			}, 2*time.Second)
+			mu.Lock()
+			info, payload := infos[0], payloads[0]
+			mu.Unlock()
+
+			if info.MetricName != "a" || !strings.HasPrefix(info.Filename, "pprof.web.") ||
+				!strings.HasSuffix(info.Filename, format.ext()) || !strings.HasSuffix(info.Comment, "Bundled with b") {
+				t.Errorf("unexpected bundle info: %+v", info)
+			}
+			files := readBundle(t, format, payload)
+			if files["same.pprof"] != "a-profile" || files["1-same.pprof"] != "b-profile" {
+				t.Errorf("unexpected bundle files: %v", files)
+			}
+			var manifest bundleManifest
+			if err := json.Unmarshal([]byte(files[bundleManifestName]), &manifest); err != nil {
+				t.Fatal(err)
+			}
+			if manifest.IncidentID != info.IncidentID || manifest.TriggeredBy != "a" || len(manifest.Files) != 2 ||
+				manifest.Files[1].Name != "1-same.pprof" || manifest.Files[1].Info.MetricName != "b" ||
+				!manifest.Files[1].Info.IsCascade {
+				t.Errorf("unexpected manifest: %+v", manifest)
+			}
+			// The bundled cascade target counts the report as its own.
+			if st := ap.status(); st[1].Name != "b" || st[1].ReportsFired == 0 {
+				t.Errorf("unexpected b status: %+v", st[1])
+			}
+		})
+	}
+}
+
+func TestCascade_bundleQueuedCollectsTriggerOnWatcher(t *testing.T) {
+	var reports atomic.Int32
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(context.Context, io.Reader, report.ReportInfo) error {
+			reports.Add(1)
+			return nil
+		})
+	ap := newTestAp(t, mockReporter)
+	ap.cascade = CascadePolicy{Groups: []CascadeGroup{}, Bundle: BundleTarGz}
+	ap.queue = newReportQueue(ap, ReportQueue{Size: 2, Workers: 1, FlushTimeout: time.Second})
+	t.Cleanup(func() { ap.stop() })
+
+	mk := func(name string) *fakeMetric {
+		return &fakeMetric{nameVal: name, thresholdVal: 10, intervalVal: time.Hour,
+			queryFn: func() (float64, error) { return 0, nil }, collectFn: byteCollect}
+	}
+	trig, target := mk("a"), mk("b")
+	a, b := newRunner(trig, MetricConfig{Threshold: 10}), newRunner(target, MetricConfig{Threshold: 10})
+
+	// The caller, a watcher, collects its own payload: Unregister and
+	// its next tick wait for that Collect. Only b is collected on the
+	// cascade goroutine.
+	ap.bundleFrom(a, a.config(), 20, newIncident("a"), []*metricRunner{b})
+	if n := trig.collectCalls.Load(); n != 1 {
+		t.Errorf("the trigger was collected %d times before bundleFrom returned, want 1", n)
+	}
+	waitFor(t, func() bool { return reports.Load() == 1 }, time.Second)
+	if n := target.collectCalls.Load(); n != 1 {
+		t.Errorf("the target was collected %d times, want 1", n)
+	}
+}
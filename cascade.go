@@ -0,0 +1,105 @@
+package autopprof
+
+// CascadeGroupBuiltIn is the name of the default cascade group: the
+// built-in cpu, mem and goroutine metrics, each breach reporting the
+// other two.
+const CascadeGroupBuiltIn = "builtin"
+
+// CascadePolicy decides which other metrics are collected and
+// reported alongside a breach, so related profiles are taken at the
+// same moment.
+type CascadePolicy struct {
+	// Disabled turns the cascade off: a breach reports only its own
+	// metric.
+	Disabled bool
+
+	// Groups are the cascade groups. When a trigger of a group fires,
+	// every other enabled member of the group is reported too; a
+	// metric in several fired groups is reported once. Nil means the
+	// single CascadeGroupBuiltIn group, the legacy behavior. A
+	// non-nil slice replaces it, so list CascadeGroupBuiltIn again to
+	// keep it.
+	Groups []CascadeGroup
+
+	// Bundle packs the breach's profile and those of its cascade into
+	// one archive, with a manifest.json listing the ReportInfo of each
+	// file, sent as a single Reporter.Report call. The zero value
+	// sends them separately.
+	Bundle BundleFormat
+}
+
+// BundleFormat is the archive format of a bundled cascade.
+type BundleFormat int
+
+const (
+	// BundleNone sends every profile of a cascade on its own.
+	BundleNone BundleFormat = iota
+	// BundleTarGz bundles a cascade into a gzipped tarball.
+	BundleTarGz
+	// BundleZip bundles a cascade into a zip archive.
+	BundleZip
+)
+
+// ext is the filename extension of the format.
+func (f BundleFormat) ext() string {
+	if f == BundleZip {
+		return ".zip"
+	}
+	return ".tar.gz"
+}
+
+// CascadeGroup is a named set of metrics reported together.
+type CascadeGroup struct {
+	Name string
+	// Members are the metric names, built-in or custom, reported when
+	// the group fires. Custom Metrics can also join by implementing
+	// CascadeMetric. Names that are not registered are skipped.
+	Members []string
+	// Triggers are the metric names whose breach fires the group; they
+	// need not be Members. Empty means every member triggers it.
+	Triggers []string
+}
+
+// CascadeMetric is an optional Metric extension that adds the metric
+// to the named cascade groups, as if it were listed in their Members.
+// A group that CascadePolicy doesn't declare is formed by the metrics
+// naming it, each of them triggering it. Read once at registration.
+type CascadeMetric interface {
+	CascadeGroups() []string
+}
+
+// cascadeBuiltInGroup is the group used when CascadePolicy.Groups is
+// nil.
+var cascadeBuiltInGroup = CascadeGroup{
+	Name:    CascadeGroupBuiltIn,
+	Members: []string{"cpu", "mem", "goroutine"},
+}
+
+// groups returns the declared groups, or the built-in default.
+func (p CascadePolicy) groups() []CascadeGroup {
+	if p.Groups == nil {
+		return []CascadeGroup{cascadeBuiltInGroup}
+	}
+	return p.Groups
+}
+
+func (p CascadePolicy) validate() error {
+	if p.Bundle < BundleNone || p.Bundle > BundleZip {
+		return ErrInvalidBundleFormat
+	}
+	seen := make(map[string]bool, len(p.Groups))
+	for _, g := range p.Groups {
+		if g.Name == "" || seen[g.Name] {
+			return ErrInvalidCascadeGroup
+		}
+		seen[g.Name] = true
+		for _, names := range [][]string{g.Members, g.Triggers} {
+			for _, name := range names {
+				if name == "" {
+					return ErrInvalidCascadeGroup
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package autopprof
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	ErrUnsupportedPlatform = errors.New(
@@ -15,16 +18,142 @@ var (
 	ErrInvalidGoroutineThreshold = errors.New(
 		"autopprof: goroutine threshold value must be greater than to 0",
 	)
+	ErrInvalidWatchInterval = errors.New(
+		"autopprof: watch interval must be a non-negative duration",
+	)
+	ErrInvalidCPUProfilingDuration = errors.New(
+		"autopprof: cpu profiling duration must be a non-negative duration",
+	)
+	ErrInvalidMinConsecutiveOverThreshold = errors.New(
+		"autopprof: min consecutive over threshold must be non-negative",
+	)
+	ErrInvalidCPUUsageWindow = errors.New(
+		"autopprof: cpu usage window must be a non-negative duration",
+	)
+	ErrInvalidReArmThreshold = errors.New(
+		"autopprof: re-arm threshold must be between 0 and the threshold",
+	)
+	ErrInvalidSustain = errors.New(
+		"autopprof: sustain must be non-negative with Samples no greater than Window",
+	)
+	ErrInvalidRate = errors.New(
+		"autopprof: rate must have a non-negative Delta and Over, set together",
+	)
+	ErrInvalidAnomaly = errors.New(
+		"autopprof: anomaly must have non-negative values and an Alpha no greater than 1",
+	)
+	ErrInvalidRule = errors.New(
+		"autopprof: rules must have unique non-empty names, a valid condition and non-negative durations",
+	)
+	ErrInvalidCascadeGroup = errors.New(
+		"autopprof: cascade groups must have unique non-empty names and non-empty metric names",
+	)
+	ErrInvalidBundleFormat = errors.New(
+		"autopprof: unknown cascade bundle format",
+	)
+	ErrInvalidCooldown = errors.New(
+		"autopprof: cooldown must be a non-negative duration",
+	)
 	ErrInvalidReportTimeout = errors.New(
 		"autopprof: report timeout must be a non-negative duration",
 	)
+	ErrInvalidReportQueue = errors.New(
+		"autopprof: report queue must not have negative values or an unknown overflow policy",
+	)
+	ErrReportDropped = errors.New(
+		"autopprof: report dropped by the report queue",
+	)
+	ErrInvalidSpool = errors.New(
+		"autopprof: spool must not have negative values",
+	)
+	ErrSpoolFull = errors.New(
+		"autopprof: report payload is larger than the spool",
+	)
+	ErrSpoolDisabled = errors.New(
+		"autopprof: spool is disabled",
+	)
+	ErrInvalidTemplate = errors.New(
+		"autopprof: invalid template",
+	)
+	ErrInvalidQueryErrorPolicy = errors.New(
+		"autopprof: query error policy must not have negative values",
+	)
 	ErrNilReporter         = errors.New("autopprof: Reporter can't be nil")
 	ErrDisableAllProfiling = errors.New("autopprof: all profiling is disabled")
 
 	ErrInvalidMetric = errors.New(
-		"autopprof: metric is invalid (nil, empty name, negative threshold/interval, or nil query/collect)",
+		"autopprof: metric is invalid (nil, empty name, negative threshold/interval/cooldown, re-arm threshold above threshold, or nil query/collect)",
+	)
+	ErrInvalidMetricConfig = errors.New(
+		"autopprof: metric config is invalid (negative value, or re-arm threshold above threshold)",
 	)
 	ErrNotStarted = errors.New(
 		"autopprof: Start() must be called before Register",
 	)
+	ErrAlreadyStarted = errors.New(
+		"autopprof: profiler is already started",
+	)
+	ErrReservedMetricName = errors.New(
+		"autopprof: metric names cpu, mem and goroutine are reserved for the built-ins",
+	)
+	ErrDuplicateMetric = errors.New(
+		"autopprof: a metric with the same name is already registered",
+	)
+	ErrMetricNotFound = errors.New(
+		"autopprof: no registered metric with the given name",
+	)
+	ErrUnregisterBuiltIn = errors.New(
+		"autopprof: built-in metrics can't be unregistered (use Disable*Prof)",
+	)
 )
+
+// multiError aggregates independent failures, e.g. from CaptureAll.
+// errors.Is and errors.As see every wrapped error, on Go versions
+// before 1.20 too.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (m multiError) Unwrap() []error { return m }
+
+func (m multiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiError) As(target any) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// phaseError tags an error with the pipeline phase it came from, so
+// log records can tell Collect failures from Reporter ones.
+type phaseError struct {
+	phase string
+	err   error
+}
+
+func (e *phaseError) Error() string { return e.phase + ": " + e.err.Error() }
+func (e *phaseError) Unwrap() error { return e.err }
+
+func phaseOf(err error) string {
+	var pe *phaseError
+	if errors.As(err, &pe) {
+		return pe.phase
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package autopprof
+
+import (
+	"io"
+	"time"
+
+	"github.com/daangn/autopprof/v2/report"
+)
+
+// Hooks are optional callbacks into the watch loop, for emitting
+// metrics or audit logs without wrapping every Metric and Reporter.
+// Nil callbacks are skipped. They run synchronously on the goroutine
+// that raised the event: a watcher, the Capture caller, a cascade
+// goroutine, or a ReportQueue worker for OnReport and OnError. Several
+// may run at once, so they must be safe for concurrent use; keep them
+// fast and non-blocking.
+type Hooks struct {
+	// OnBreach is called on every tick whose value crosses the
+	// threshold, before debounce decides whether to fire. It is also
+	// called for breaches still pending their Sustain condition.
+	OnBreach func(Event)
+	// OnDebounced is called when a breach is suppressed by debounce.
+	OnDebounced func(Event)
+	// OnCollectStart is called right before Metric.Collect.
+	OnCollectStart func(Event)
+	// OnCollectEnd is called after Metric.Collect with Duration, Size
+	// and Err filled in.
+	OnCollectEnd func(Event)
+	// OnReport is called after Reporter.Report; Err is nil on success.
+	OnReport func(Event)
+	// OnCascade is called when a breach fires at least one cascade
+	// group; the Event describes the triggering metric.
+	OnCascade func(Event)
+	// OnWatcherExit is called when a watcher goroutine returns. Err is
+	// nil for Stop/Unregister and the last Query error when the
+	// QueryErrorPolicy budget ran out.
+	OnWatcherExit func(Event)
+	// OnError is called for every query, collect or report failure,
+	// with Phase telling which.
+	OnError func(Event)
+}
+
+// Event describes one watch-loop occurrence passed to Hooks.
+type Event struct {
+	MetricName string
+	Value      float64
+	Threshold  float64
+
+	// Info is the ReportInfo of the payload, set from OnCollectEnd on
+	// once Collect returned a Reader.
+	Info report.ReportInfo
+
+	// Phase is "query", "collect" or "report" for OnError.
+	Phase string
+	// Duration is how long Collect (OnCollectEnd) or Report (OnReport)
+	// took.
+	Duration time.Duration
+	// Size is the payload size in bytes (OnCollectEnd, OnReport), or -1
+	// when the Reader can't tell without being consumed.
+	Size int64
+	// Err is the failure, if any.
+	Err error
+}
+
+func callHook(fn func(Event), e Event) {
+	if fn != nil {
+		fn(e)
+	}
+}
+
+// payloadSize reports the unread length of r without consuming it, or
+// -1 if r can't tell.
+func payloadSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case nil:
+		return 0
+	case interface{ Len() int }:
+		return int64(v.Len())
+	case io.Seeker:
+		cur, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := v.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err := v.Seek(cur, io.SeekStart); err != nil {
+			return -1
+		}
+		return end - cur
+	}
+	return -1
+}
@@ -0,0 +1,107 @@
+package autopprof
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives autopprof's diagnostics as structured records. args
+// are alternating key/value pairs, e.g. "metric", "cpu", "error", err.
+// The method set matches *slog.Logger, so one can be passed as is.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Log record field keys.
+const (
+	logKeyMetric      = "metric"
+	logKeyValue       = "value"
+	logKeyThreshold   = "threshold"
+	logKeyError       = "error"
+	logKeyPhase       = "phase"
+	logKeyTriggeredBy = "triggered_by"
+)
+
+// Pipeline phases reported in the "phase" field.
+const (
+	phaseQuery   = "query"
+	phaseCollect = "collect"
+	phaseReport  = "report"
+)
+
+// stdLogger is the default Logger. It writes each record as one line
+// through the standard log package, like autopprof always did. The
+// records autopprof logged before Option.Logger keep their old text;
+// the others get their fields appended as key=value. Debug records
+// are dropped.
+type stdLogger struct{}
+
+func (stdLogger) Debug(string, ...any)          {}
+func (stdLogger) Info(msg string, args ...any)  { log.Println(stdLine(msg, args)) }
+func (stdLogger) Warn(msg string, args ...any)  { log.Println(stdLine(msg, args)) }
+func (stdLogger) Error(msg string, args ...any) { log.Println(stdLine(msg, args)) }
+
+// legacyLines renders the records that predate Option.Logger as the
+// lines autopprof wrote for them, from the record's fields.
+var legacyLines = map[string]func(fields map[any]any) string{
+	"autopprof: metric query failed, watcher exits": func(f map[any]any) string {
+		return fmt.Sprintf("autopprof: metric %q query failed: %v", f[logKeyMetric], f[logKeyError])
+	},
+	"autopprof: metric report failed": func(f map[any]any) string {
+		return fmt.Sprintf("autopprof: metric %q report failed: %v", f[logKeyMetric], legacyError(f[logKeyError]))
+	},
+	"autopprof: cascade query failed": func(f map[any]any) string {
+		return fmt.Sprintf("autopprof: cascade query %q: %v", f[logKeyMetric], f[logKeyError])
+	},
+	"autopprof: cascade report failed": func(f map[any]any) string {
+		return fmt.Sprintf("autopprof: cascade report %q: %v", f[logKeyMetric], legacyError(f[logKeyError]))
+	},
+	"autopprof: disable the cpu profiling due to the CPU quota isn't set": func(map[any]any) string {
+		return "autopprof: disable the cpu profiling due to the CPU quota isn't set"
+	},
+}
+
+// legacyError drops the "report: " prefix that Reporter errors didn't
+// have; Collect errors always read "collect: ...".
+func legacyError(v any) any {
+	var pe *phaseError
+	if err, ok := v.(error); ok && errors.As(err, &pe) && pe.phase == phaseReport {
+		return pe.err
+	}
+	return v
+}
+
+// stdLine is the line stdLogger writes for a record.
+func stdLine(msg string, args []any) string {
+	line, ok := legacyLines[msg]
+	if !ok {
+		return formatRecord(msg, args)
+	}
+	fields := make(map[any]any, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+	return line(fields)
+}
+
+func formatRecord(msg string, args []any) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+			break
+		}
+		v := fmt.Sprint(args[i+1])
+		if strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %v=%s", args[i], v)
+	}
+	return b.String()
+}
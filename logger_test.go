@@ -0,0 +1,90 @@
+package autopprof
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFormatRecord(t *testing.T) {
+	testCases := []struct {
+		name string
+		msg  string
+		args []any
+		want string
+	}{
+		{
+			name: "no fields",
+			msg:  "autopprof: hello",
+			want: "autopprof: hello",
+		},
+		{
+			name: "plain and quoted values",
+			msg:  "autopprof: metric report failed",
+			args: []any{"metric", "cpu", "value", 0.9, "error", errors.New("bad gateway")},
+			want: `autopprof: metric report failed metric=cpu value=0.9 error="bad gateway"`,
+		},
+		{
+			name: "dangling key",
+			msg:  "m",
+			args: []any{"metric"},
+			want: "m !BADKEY=metric",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatRecord(tc.msg, tc.args); got != tc.want {
+				t.Errorf("formatRecord() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStdLine_legacy(t *testing.T) {
+	boom := errors.New("boom")
+	testCases := []struct {
+		msg  string
+		args []any
+		want string
+	}{
+		{
+			msg:  "autopprof: metric query failed, watcher exits",
+			args: []any{logKeyMetric, "x", logKeyPhase, phaseQuery, logKeyError, boom},
+			want: `autopprof: metric "x" query failed: boom`,
+		},
+		{
+			msg:  "autopprof: metric report failed",
+			args: []any{logKeyMetric, "x", logKeyValue, 0.9, logKeyError, &phaseError{phase: phaseReport, err: boom}},
+			want: `autopprof: metric "x" report failed: boom`,
+		},
+		{
+			msg:  "autopprof: metric report failed",
+			args: []any{logKeyMetric, "x", logKeyError, &phaseError{phase: phaseCollect, err: boom}},
+			want: `autopprof: metric "x" report failed: collect: boom`,
+		},
+		{
+			msg:  "autopprof: cascade query failed",
+			args: []any{logKeyMetric, "mem", logKeyTriggeredBy, "cpu", logKeyError, boom},
+			want: `autopprof: cascade query "mem": boom`,
+		},
+		{
+			msg:  "autopprof: cascade report failed",
+			args: []any{logKeyMetric, "mem", logKeyError, &phaseError{phase: phaseReport, err: boom}},
+			want: `autopprof: cascade report "mem": boom`,
+		},
+		{
+			msg:  "autopprof: disable the cpu profiling due to the CPU quota isn't set",
+			args: []any{logKeyMetric, "cpu", logKeyError, boom},
+			want: "autopprof: disable the cpu profiling due to the CPU quota isn't set",
+		},
+		{
+			msg:  "autopprof: report dropped",
+			args: []any{logKeyMetric, "cpu"},
+			want: "autopprof: report dropped metric=cpu",
+		},
+	}
+	for _, tc := range testCases {
+		if got := stdLine(tc.msg, tc.args); got != tc.want {
+			t.Errorf("stdLine(%q) = %q, want %q", tc.msg, got, tc.want)
+		}
+	}
+}
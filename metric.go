@@ -25,13 +25,14 @@ type CollectResult struct {
 // watchers are pre-defined implementations; users register additional
 // Metrics via Option.Metrics or autopprof.Register.
 //
-// Thread-safety: autopprof only calls Query and Collect from the
-// Metric's own watcher goroutine, so implementations do not need
-// internal synchronization. (The ReportAll cascade touches only
-// built-ins.)
+// Thread-safety: autopprof serializes Query and Collect per Metric —
+// the watcher goroutine, the built-in cascade and Capture never call
+// them concurrently — so implementations do not need internal
+// synchronization.
 //
 // Name/Threshold/Interval are read once at registration. Interval == 0
-// means "use the global watchInterval (default 5s)".
+// means "use Option.WatchInterval (default 5s)". Use Update to
+// change the threshold or interval of a live metric.
 type Metric interface {
 	Name() string
 	Threshold() float64
@@ -40,6 +41,286 @@ type Metric interface {
 	Collect(value float64) (CollectResult, error)
 }
 
+// CooldownMetric is an optional Metric extension that overrides
+// Option.Cooldown: the minimum wall-clock time between two
+// threshold-triggered reports of the metric. Read once at
+// registration; zero means "use Option.Cooldown".
+type CooldownMetric interface {
+	Cooldown() time.Duration
+}
+
+// HysteresisMetric is an optional Metric extension. After a report
+// fires, the metric only fires again (outside the periodic repeat of
+// a sustained breach) once the value has dropped below
+// ReArmThreshold, or risen above it for DirectionBelow. Read once at
+// registration; zero means "re-arm at Threshold", the legacy
+// behavior.
+type HysteresisMetric interface {
+	ReArmThreshold() float64
+}
+
+// Direction is the side of the threshold on which a metric breaches.
+type Direction int
+
+const (
+	// DirectionAbove breaches at or above the threshold, the default.
+	DirectionAbove Direction = iota
+	// DirectionBelow breaches at or below the threshold, for signals
+	// that are bad when low: cache hit ratio, free connection slots,
+	// throughput.
+	DirectionBelow
+)
+
+// String returns "above" or "below".
+func (d Direction) String() string {
+	switch d {
+	case DirectionAbove:
+		return "above"
+	case DirectionBelow:
+		return "below"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// DirectionalMetric is an optional Metric extension that sets the
+// side of the threshold the metric breaches on. Read once at
+// registration. The built-ins always breach above.
+type DirectionalMetric interface {
+	Direction() Direction
+}
+
+// SustainedMetric is an optional Metric extension that requires a
+// breach to persist before it fires, like the "for" clause of an
+// alerting rule. Read once at registration; the zero Sustain fires on
+// the first breaching sample.
+type SustainedMetric interface {
+	Sustain() Sustain
+}
+
+// Sustain is the sustained-breach condition of a metric. A breach
+// fires only once every set condition holds; the zero value fires on
+// the first breaching sample.
+type Sustain struct {
+	// For is how long the value must stay breaching, measured from
+	// the first of an unbroken run of breaching samples.
+	For time.Duration
+	// Samples is the number of breaching samples required among the
+	// last Window samples. Window == 0 means Samples consecutive
+	// samples.
+	Samples int
+	Window  int
+}
+
+func (s Sustain) validate() bool {
+	return s.For >= 0 && s.Samples >= 0 && s.Window >= 0 &&
+		(s.Window == 0 || s.Samples <= s.Window)
+}
+
+// window is the number of samples the condition looks back over.
+func (s Sustain) window() int {
+	if s.Window == 0 {
+		return s.Samples
+	}
+	return s.Window
+}
+
+// RateMetric is an optional Metric extension that also fires the
+// metric on fast change, however far the value still is from the
+// threshold, e.g. a leak long before the limit. Read once at
+// registration; the zero Rate disables it.
+type RateMetric interface {
+	Rate() Rate
+}
+
+// Rate is a rate-of-change trigger. It breaches when the value grew by
+// more than Delta within the last Over, or fell by more than Delta for
+// DirectionBelow. Delta and Over are set together; the zero value
+// disables it.
+type Rate struct {
+	Delta float64
+	Over  time.Duration
+}
+
+func (r Rate) validate() bool {
+	return r.Delta >= 0 && r.Over >= 0 && (r.Delta == 0) == (r.Over == 0)
+}
+
+func (r Rate) enabled() bool { return r.Over > 0 }
+
+// AnomalyMetric is an optional Metric extension that also fires the
+// metric when its value departs from its own recent baseline, for
+// metrics whose normal level varies too much for a static threshold.
+// Read once at registration; the zero Anomaly disables it.
+type AnomalyMetric interface {
+	Anomaly() Anomaly
+}
+
+const (
+	defaultAnomalyAlpha  = 0.05
+	defaultAnomalyWarmUp = 60
+)
+
+// Anomaly is an adaptive-baseline trigger. The watcher keeps an EWMA
+// of the mean and variance of the metric's values and breaches when a
+// value is more than K standard deviations above the mean, or below
+// it for DirectionBelow. The standard deviation is taken as at least
+// 1% of the mean, so a flat baseline doesn't fire on noise.
+type Anomaly struct {
+	// K is the deviation, in standard deviations, that breaches. Zero
+	// disables the trigger.
+	K float64
+	// Alpha is the EWMA smoothing factor, in (0, 1]: the weight of the
+	// newest value. Smaller values make a longer memory. Defaults to
+	// 0.05, about the last 40 samples.
+	Alpha float64
+	// WarmUp is the number of samples the baseline learns from before
+	// it can breach. Defaults to 60.
+	WarmUp int
+	// Floor is the value an anomaly must reach, or drop to for
+	// DirectionBelow, to fire, e.g. 0.2 to ignore a 3x jump of an idle
+	// CPU. Zero means no floor.
+	Floor float64
+}
+
+func (a Anomaly) validate() bool {
+	return a.K >= 0 && a.Alpha >= 0 && a.Alpha <= 1 && a.WarmUp >= 0 && a.Floor >= 0
+}
+
+func (a Anomaly) enabled() bool { return a.K > 0 }
+
+// withDefaults fills the zero Alpha and WarmUp of a.
+func (a Anomaly) withDefaults() Anomaly {
+	if a.Alpha == 0 {
+		a.Alpha = defaultAnomalyAlpha
+	}
+	if a.WarmUp == 0 {
+		a.WarmUp = defaultAnomalyWarmUp
+	}
+	return a
+}
+
+// MetricConfig is the live-tunable part of a registered Metric. Update
+// replaces the whole config of one metric at once; zero Interval,
+// MinConsecutiveOverThreshold and Cooldown fall back to the instance
+// defaults, as they do at registration.
+type MetricConfig struct {
+	// Threshold is the value at or above which, or at or below which
+	// for DirectionBelow, the metric fires.
+	Threshold float64
+	// Direction is the side of Threshold the metric breaches on. It
+	// is a property of the signal, set at registration: Update keeps
+	// it, and rejects a config that asks for the other side.
+	Direction Direction
+	// Interval is the watch interval.
+	Interval time.Duration
+	// MinConsecutiveOverThreshold is the number of ticks a breach is
+	// debounced for after it fires.
+	MinConsecutiveOverThreshold int
+	// Cooldown is the minimum wall-clock time between two
+	// threshold-triggered reports.
+	Cooldown time.Duration
+	// ReArmThreshold is the value the metric must drop below, or rise
+	// above for DirectionBelow, before another breach can fire. Zero
+	// means Threshold.
+	ReArmThreshold float64
+	// Sustain is how long or how often the value must breach before
+	// a report fires.
+	Sustain Sustain
+	// Rate also fires the metric when the value changes fast. A rate
+	// breach holds off the re-arm like a threshold breach does.
+	Rate Rate
+	// Anomaly also fires the metric when the value departs from its
+	// baseline, holding off the re-arm the same way.
+	Anomaly Anomaly
+	// Disabled pauses the watcher (and drops the metric from the
+	// built-in cascade) without stopping its goroutine.
+	Disabled bool
+}
+
+func (c MetricConfig) validate() error {
+	if c.Threshold < 0 || c.Interval < 0 || c.MinConsecutiveOverThreshold < 0 ||
+		c.Cooldown < 0 || c.ReArmThreshold < 0 || !c.Sustain.validate() || !c.Rate.validate() ||
+		!c.Anomaly.validate() {
+		return ErrInvalidMetricConfig
+	}
+	switch c.Direction {
+	case DirectionAbove:
+		if c.ReArmThreshold > c.Threshold {
+			return ErrInvalidMetricConfig
+		}
+	case DirectionBelow:
+		if c.ReArmThreshold != 0 && c.ReArmThreshold < c.Threshold {
+			return ErrInvalidMetricConfig
+		}
+	default:
+		return ErrInvalidMetricConfig
+	}
+	return nil
+}
+
+// breached reports whether value is on the breaching side of the
+// threshold.
+func (c MetricConfig) breached(value float64) bool {
+	if c.Direction == DirectionBelow {
+		return value <= c.Threshold
+	}
+	return value >= c.Threshold
+}
+
+// rearmed reports whether value is past the re-arm threshold, back on
+// the healthy side.
+func (c MetricConfig) rearmed(value float64) bool {
+	if c.Direction == DirectionBelow {
+		return value > c.reArmThreshold()
+	}
+	return value < c.reArmThreshold()
+}
+
+// reArmThreshold is the effective re-arm level.
+func (c MetricConfig) reArmThreshold() float64 {
+	if c.ReArmThreshold == 0 {
+		return c.Threshold
+	}
+	return c.ReArmThreshold
+}
+
+// metricConfigOf reads the registration-time config of m, including
+// the optional extension interfaces.
+func metricConfigOf(m Metric) MetricConfig {
+	cfg := MetricConfig{
+		Threshold: m.Threshold(),
+		Interval:  m.Interval(),
+	}
+	if c, ok := m.(CooldownMetric); ok {
+		cfg.Cooldown = c.Cooldown()
+	}
+	if h, ok := m.(HysteresisMetric); ok {
+		cfg.ReArmThreshold = h.ReArmThreshold()
+	}
+	if s, ok := m.(SustainedMetric); ok {
+		cfg.Sustain = s.Sustain()
+	}
+	if d, ok := m.(DirectionalMetric); ok {
+		cfg.Direction = d.Direction()
+	}
+	if r, ok := m.(RateMetric); ok {
+		cfg.Rate = r.Rate()
+	}
+	if a, ok := m.(AnomalyMetric); ok {
+		cfg.Anomaly = a.Anomaly()
+	}
+	return cfg
+}
+
+// MetricOption customizes a Metric built by NewMetric.
+type MetricOption func(*basicMetric)
+
+// WithDirection sets the side of the threshold the metric breaches
+// on. See DirectionalMetric.
+func WithDirection(d Direction) MetricOption {
+	return func(b *basicMetric) { b.direction = d }
+}
+
 // NewMetric is a convenience constructor. Nil query/collect surface
 // ErrInvalidMetric at call time instead of panicking.
 func NewMetric(
@@ -48,6 +329,7 @@ func NewMetric(
 	interval time.Duration,
 	query func() (float64, error),
 	collect func(value float64) (CollectResult, error),
+	opts ...MetricOption,
 ) Metric {
 	if query == nil {
 		query = func() (float64, error) { return 0, ErrInvalidMetric }
@@ -57,19 +339,24 @@ func NewMetric(
 			return CollectResult{}, ErrInvalidMetric
 		}
 	}
-	return &basicMetric{
+	b := &basicMetric{
 		name:      name,
 		threshold: threshold,
 		interval:  interval,
 		query:     query,
 		collect:   collect,
 	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 type basicMetric struct {
 	name      string
 	threshold float64
 	interval  time.Duration
+	direction Direction
 	query     func() (float64, error)
 	collect   func(value float64) (CollectResult, error)
 }
@@ -79,17 +366,29 @@ func (b *basicMetric) Threshold() float64                       { return b.thres
 func (b *basicMetric) Interval() time.Duration                  { return b.interval }
 func (b *basicMetric) Query() (float64, error)                  { return b.query() }
 func (b *basicMetric) Collect(v float64) (CollectResult, error) { return b.collect(v) }
+func (b *basicMetric) Direction() Direction                     { return b.direction }
 
 func validateMetric(m Metric) error {
 	if m == nil {
 		return ErrInvalidMetric
 	}
-	if m.Name() == "" || m.Threshold() < 0 || m.Interval() < 0 {
+	if m.Name() == "" {
+		return ErrInvalidMetric
+	}
+	if err := metricConfigOf(m).validate(); err != nil {
 		return ErrInvalidMetric
 	}
 	return nil
 }
 
+func isBuiltInName(name string) bool {
+	switch name {
+	case "cpu", "mem", "goroutine":
+		return true
+	}
+	return false
+}
+
 func hostnameSafe() string {
 	h, _ := os.Hostname()
 	return h
@@ -98,7 +397,6 @@ func hostnameSafe() string {
 func collectProfile(
 	app, filenameFmt string,
 	profile func() ([]byte, error),
-	comment string,
 ) (CollectResult, error) {
 	b, err := profile()
 	if err != nil {
@@ -108,7 +406,6 @@ func collectProfile(
 	return CollectResult{
 		Reader:   bytes.NewReader(b),
 		Filename: fmt.Sprintf(filenameFmt, app, hostnameSafe(), now),
-		Comment:  comment,
 	}, nil
 }
 
@@ -124,7 +421,46 @@ func defaultFilename(metricName string) string {
 	)
 }
 
-func defaultComment(metricName string, value, threshold float64) string {
+// commenter is implemented by the built-ins. Their Collect leaves the
+// Comment empty so fireReport can format it against the runner's live
+// threshold, which Update may have moved.
+type commenter interface {
+	comment(value, threshold float64) string
+}
+
+// rateCommenter is implemented by the built-ins that take a Rate, to
+// format the comment of a rate breach.
+type rateCommenter interface {
+	rateComment(value, change float64, rate Rate) string
+}
+
+// anomalyCommenter is implemented by the built-ins to format the
+// comment of an anomaly breach.
+type anomalyCommenter interface {
+	anomalyComment(value, baseline, deviation float64) string
+}
+
+func defaultAnomalyComment(metricName string, value, baseline, deviation float64) string {
+	return fmt.Sprintf(
+		":rotating_light:[%s] value=%.2f is %.1fσ off baseline=%.2f",
+		metricName, value, deviation, baseline,
+	)
+}
+
+func defaultRateComment(metricName string, value, change float64, rate Rate) string {
+	return fmt.Sprintf(
+		":rotating_light:[%s] value=%.2f changed by %.2f in %s > rate=%.2f",
+		metricName, value, change, rate.Over, rate.Delta,
+	)
+}
+
+func defaultComment(metricName string, value, threshold float64, direction Direction) string {
+	if direction == DirectionBelow {
+		return fmt.Sprintf(
+			":rotating_light:[%s] value=%.2f below threshold=%.2f",
+			metricName, value, threshold,
+		)
+	}
 	return fmt.Sprintf(
 		":rotating_light:[%s] value=%.2f threshold=%.2f",
 		metricName, value, threshold,
@@ -15,24 +15,38 @@ const (
 
 	cpuProfileFilenameFmt = "pprof.%s.%s.samples.cpu.%s.pprof"
 	cpuCommentFmt         = ":rotating_light:[CPU] usage (*%.2f%%*) > threshold (*%.2f%%*)"
+	cpuAnomalyCommentFmt  = ":rotating_light:[CPU] usage (*%.2f%%*) is *%.1fσ* off baseline (*%.2f%%*)"
 )
 
 type cpuMetric struct {
-	app       string
-	threshold float64
-	cg        queryer.CgroupsQueryer
-	p         profiler
+	app            string
+	threshold      float64
+	reArmThreshold float64
+	sustain        Sustain
+	anomaly        Anomaly
+	cg             queryer.CgroupsQueryer
+	p              profiler
 }
 
 func (m *cpuMetric) Name() string            { return MetricNameCPU }
 func (m *cpuMetric) Threshold() float64      { return m.threshold }
 func (m *cpuMetric) Interval() time.Duration { return 0 }
+func (m *cpuMetric) ReArmThreshold() float64 { return m.reArmThreshold }
+func (m *cpuMetric) Sustain() Sustain        { return m.sustain }
+func (m *cpuMetric) Anomaly() Anomaly        { return m.anomaly }
 func (m *cpuMetric) Query() (float64, error) { return m.cg.CPUUsage() }
 
 func (m *cpuMetric) Collect(value float64) (CollectResult, error) {
 	return collectProfile(
 		m.app, cpuProfileFilenameFmt,
 		m.p.profileCPU,
-		fmt.Sprintf(cpuCommentFmt, value*100, m.threshold*100),
 	)
 }
+
+func (m *cpuMetric) comment(value, threshold float64) string {
+	return fmt.Sprintf(cpuCommentFmt, value*100, threshold*100)
+}
+
+func (m *cpuMetric) anomalyComment(value, baseline, deviation float64) string {
+	return fmt.Sprintf(cpuAnomalyCommentFmt, value*100, deviation, baseline*100)
+}
@@ -15,21 +15,31 @@ const (
 
 	goroutineProfileFilenameFmt = "pprof.%s.%s.goroutine.%s.pprof"
 	goroutineCommentFmt         = ":rotating_light:[GOROUTINE] count (*%d*) > threshold (*%d*)"
+	goroutineRateCommentFmt     = ":rotating_light:[GOROUTINE] count (*%d*) grew by *%d* in %s > rate (*%d*)"
+	goroutineAnomalyCommentFmt  = ":rotating_light:[GOROUTINE] count (*%d*) is *%.1fσ* off baseline (*%d*)"
 )
 
 // goroutineMetric keeps its threshold as int to mirror
-// Option.GoroutineThreshold; the int(value) cast in Collect preserves
+// Option.GoroutineThreshold; the int(value) cast in comment preserves
 // the integer-formatted legacy comment.
 type goroutineMetric struct {
-	app       string
-	threshold int
-	rt        queryer.RuntimeQueryer
-	p         profiler
+	app            string
+	threshold      int
+	reArmThreshold int
+	sustain        Sustain
+	rate           Rate
+	anomaly        Anomaly
+	rt             queryer.RuntimeQueryer
+	p              profiler
 }
 
 func (m *goroutineMetric) Name() string            { return MetricNameGoroutine }
 func (m *goroutineMetric) Threshold() float64      { return float64(m.threshold) }
 func (m *goroutineMetric) Interval() time.Duration { return 0 }
+func (m *goroutineMetric) ReArmThreshold() float64 { return float64(m.reArmThreshold) }
+func (m *goroutineMetric) Sustain() Sustain        { return m.sustain }
+func (m *goroutineMetric) Rate() Rate              { return m.rate }
+func (m *goroutineMetric) Anomaly() Anomaly        { return m.anomaly }
 func (m *goroutineMetric) Query() (float64, error) {
 	return float64(m.rt.GoroutineCount()), nil
 }
@@ -38,6 +48,17 @@ func (m *goroutineMetric) Collect(value float64) (CollectResult, error) {
 	return collectProfile(
 		m.app, goroutineProfileFilenameFmt,
 		m.p.profileGoroutine,
-		fmt.Sprintf(goroutineCommentFmt, int(value), m.threshold),
 	)
 }
+
+func (m *goroutineMetric) comment(value, threshold float64) string {
+	return fmt.Sprintf(goroutineCommentFmt, int(value), int(threshold))
+}
+
+func (m *goroutineMetric) rateComment(value, change float64, rate Rate) string {
+	return fmt.Sprintf(goroutineRateCommentFmt, int(value), int(change), rate.Over, int(rate.Delta))
+}
+
+func (m *goroutineMetric) anomalyComment(value, baseline, deviation float64) string {
+	return fmt.Sprintf(goroutineAnomalyCommentFmt, int(value), deviation, int(baseline))
+}
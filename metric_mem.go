@@ -15,24 +15,45 @@ const (
 
 	heapProfileFilenameFmt = "pprof.%s.%s.alloc_objects.alloc_space.inuse_objects.inuse_space.%s.pprof"
 	memCommentFmt          = ":rotating_light:[MEM] usage (*%.2f%%*) > threshold (*%.2f%%*)"
+	memRateCommentFmt      = ":rotating_light:[MEM] usage (*%.2f%%*) grew *%.2f%%* in %s > rate (*%.2f%%*)"
+	memAnomalyCommentFmt   = ":rotating_light:[MEM] usage (*%.2f%%*) is *%.1fσ* off baseline (*%.2f%%*)"
 )
 
 type memMetric struct {
-	app       string
-	threshold float64
-	cg        queryer.CgroupsQueryer
-	p         profiler
+	app            string
+	threshold      float64
+	reArmThreshold float64
+	sustain        Sustain
+	rate           Rate
+	anomaly        Anomaly
+	cg             queryer.CgroupsQueryer
+	p              profiler
 }
 
 func (m *memMetric) Name() string            { return MetricNameMem }
 func (m *memMetric) Threshold() float64      { return m.threshold }
 func (m *memMetric) Interval() time.Duration { return 0 }
+func (m *memMetric) ReArmThreshold() float64 { return m.reArmThreshold }
+func (m *memMetric) Sustain() Sustain        { return m.sustain }
+func (m *memMetric) Rate() Rate              { return m.rate }
+func (m *memMetric) Anomaly() Anomaly        { return m.anomaly }
 func (m *memMetric) Query() (float64, error) { return m.cg.MemUsage() }
 
 func (m *memMetric) Collect(value float64) (CollectResult, error) {
 	return collectProfile(
 		m.app, heapProfileFilenameFmt,
 		m.p.profileHeap,
-		fmt.Sprintf(memCommentFmt, value*100, m.threshold*100),
 	)
 }
+
+func (m *memMetric) comment(value, threshold float64) string {
+	return fmt.Sprintf(memCommentFmt, value*100, threshold*100)
+}
+
+func (m *memMetric) rateComment(value, change float64, rate Rate) string {
+	return fmt.Sprintf(memRateCommentFmt, value*100, change*100, rate.Over, rate.Delta*100)
+}
+
+func (m *memMetric) anomalyComment(value, baseline, deviation float64) string {
+	return fmt.Sprintf(memAnomalyCommentFmt, value*100, deviation, baseline*100)
+}
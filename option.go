@@ -14,7 +14,16 @@ const (
 	defaultWatchInterval               = 5 * time.Second
 	defaultCPUProfilingDuration        = 10 * time.Second
 	defaultMinConsecutiveOverThreshold = 12 // 12 * 5s == 1 minute
+	defaultCPUUsageWindow              = 2 * time.Minute
 	defaultReportTimeout               = 5 * time.Second
+	defaultQueryMaxConsecutiveErrors   = 5 // 1s+2s+4s+8s of backoff
+	defaultQueryRetryInitialBackoff    = time.Second
+	defaultQueryRetryMaxBackoff        = time.Minute
+	defaultReportQueueWorkers          = 1
+	defaultReportQueueFlushTimeout     = 30 * time.Second
+	defaultSpoolMaxBytes               = 64 << 20 // 64 MiB
+	defaultSpoolMaxAge                 = 24 * time.Hour
+	defaultSpoolRetryInterval          = time.Minute
 )
 
 // Option is the configuration for autopprof.
@@ -47,6 +56,85 @@ type Option struct {
 	// when the goroutine count is higher than this threshold.
 	GoroutineThreshold int
 
+	// WatchInterval is how often the built-ins, and custom Metrics
+	// with a zero Interval, are queried. Defaults to 5s when left zero.
+	WatchInterval time.Duration
+
+	// CPUProfilingDuration is how long a cpu profile records. The
+	// watcher that collects it is blocked meanwhile. Defaults to 10s
+	// when left zero.
+	CPUProfilingDuration time.Duration
+
+	// MinConsecutiveOverThreshold is the number of ticks a breach is
+	// debounced for after it fires: a metric staying above its
+	// threshold re-fires every MinConsecutiveOverThreshold ticks.
+	// Defaults to 12 (one minute at the default interval) when left
+	// zero.
+	MinConsecutiveOverThreshold int
+
+	// CPUUsageWindow is the span the cpu usage is averaged over. The
+	// usage is sampled every WatchInterval, so the window holds
+	// CPUUsageWindow / WatchInterval samples (at least 2), and the cpu
+	// usage reads 0 until the window has filled. Defaults to 2m when
+	// left zero.
+	CPUUsageWindow time.Duration
+
+	// CPUReArmThreshold, MemReArmThreshold and GoroutineReArmThreshold
+	// add hysteresis to the built-ins: after a report, the value must
+	// drop below the re-arm threshold before another breach can fire,
+	// so a value oscillating around the threshold doesn't re-fire on
+	// every dip. Zero re-arms below the threshold itself.
+	CPUReArmThreshold       float64
+	MemReArmThreshold       float64
+	GoroutineReArmThreshold int
+
+	// CPUSustain, MemSustain and GoroutineSustain require a built-in's
+	// breach to persist, for a duration or N of the last M samples,
+	// before it fires, so a momentary spike doesn't trigger a profile
+	// and the cascade. The zero value fires on the first breaching
+	// sample.
+	CPUSustain       Sustain
+	MemSustain       Sustain
+	GoroutineSustain Sustain
+
+	// MemRate and GoroutineRate also fire the mem and goroutine
+	// built-ins on fast growth, however far they still are from the
+	// threshold, so a leak is profiled while it happens rather than at
+	// the OOM edge. Rate{Delta: 0.1, Over: 5 * time.Minute} fires on
+	// memory growing by 10% of the limit within 5 minutes,
+	// Rate{Delta: 2000, Over: time.Minute} on 2000 more goroutines
+	// within a minute. The zero value disables it.
+	MemRate       Rate
+	GoroutineRate Rate
+
+	// CPUAnomaly, MemAnomaly and GoroutineAnomaly also fire a built-in
+	// when its value departs from its own baseline, e.g. CPU at 3x its
+	// normal level for the time of day while still under
+	// CPUThreshold. The zero value disables it.
+	CPUAnomaly       Anomaly
+	MemAnomaly       Anomaly
+	GoroutineAnomaly Anomaly
+
+	// Cooldown is the minimum wall-clock time between two
+	// threshold-triggered reports of the same metric. Custom Metrics
+	// can override it by implementing CooldownMetric. Zero disables it.
+	Cooldown time.Duration
+
+	// GlobalCooldown is the minimum wall-clock time between two
+	// threshold-triggered reports across all metrics of the instance.
+	// The cascade of a fired breach is not held back by it. Zero
+	// disables it.
+	GlobalCooldown time.Duration
+
+	// Cascade configures which metrics are reported alongside a
+	// breach. The zero value cascades among the enabled built-ins.
+	Cascade CascadePolicy
+
+	// Rules fire collections on conditions combining the latest
+	// values of several metrics, e.g. All(Above("cpu", 0.7),
+	// Above("goroutine", 10000)).
+	Rules []Rule
+
 	// Reporter is the reporter to send the profiling report. Must
 	// implement the report.Reporter interface.
 	Reporter report.Reporter
@@ -55,13 +143,217 @@ type Option struct {
 	// as the ctx deadline. Defaults to 5s when left zero.
 	ReportTimeout time.Duration
 
+	// ReportQueue moves Reporter.Report calls off the watchers onto a
+	// bounded queue served by worker goroutines, so a slow upload
+	// never delays the next query. The zero value reports
+	// synchronously from the watcher, like v2.0.
+	ReportQueue ReportQueue
+
+	// Spool keeps the reports the Reporter failed to deliver on disk
+	// and redelivers them once it recovers. The zero value disables
+	// it.
+	Spool SpoolOption
+
 	// App is embedded in built-in CPU/Mem/Goroutine filenames as the
 	// "<app>" segment. Defaults to "autopprof" when left empty.
 	App string
 
+	// Labels are static key/value pairs describing this instance, such
+	// as the environment or region. Every ReportInfo carries them, and
+	// the templates can use them.
+	Labels map[string]string
+
+	// CommentTemplate is a text/template for the comment of the
+	// built-ins' reports and of custom Metrics returning an empty
+	// Comment. It executes with TemplateData. Empty keeps the default
+	// Slack-flavored comments, e.g.
+	//
+	//	[{{.MetricName}}] {{percent .Value}}% > {{percent .Threshold}}% on {{.Hostname}}
+	CommentTemplate string
+
+	// FilenameTemplate is a text/template for the filename of the
+	// built-ins' reports and of custom Metrics returning an empty
+	// Filename. It executes with TemplateData. Empty keeps the default
+	// filenames, e.g.
+	//
+	//	{{.App}}/{{.Time.Format "2006-01-02"}}/{{.Hostname}}/{{.MetricName}}.pprof
+	FilenameTemplate string
+
 	// Metrics are user-defined Metrics registered at Start. Additional
 	// metrics can be added later via autopprof.Register.
 	Metrics []Metric
+
+	// QueryErrorPolicy controls how watchers react to Query errors.
+	// The zero value gives up on the first error, like v2.0.
+	QueryErrorPolicy QueryErrorPolicy
+
+	// Logger receives autopprof's diagnostics (query, collect, report
+	// and cascade failures, and start-up notices) with structured
+	// fields. A *slog.Logger satisfies it. Defaults to the standard log
+	// package when left nil.
+	Logger Logger
+
+	// Hooks are callbacks for watch-loop events: breach, debounce,
+	// collect, report, cascade, watcher exit and errors.
+	Hooks Hooks
+}
+
+// validReArm reports whether reArm fits below the effective threshold
+// (threshold, or def when threshold is zero).
+func validReArm(reArm, threshold, def float64) bool {
+	if threshold == 0 {
+		threshold = def
+	}
+	return reArm >= 0 && reArm <= threshold
+}
+
+// QueryErrorPolicy is the retry policy for failing Metric.Query calls.
+// A failed query is retried with exponential backoff until it
+// succeeds or MaxConsecutiveErrors failures in a row exhaust the
+// budget, at which point the watcher exits and the metric shows up in
+// FailedMetrics.
+type QueryErrorPolicy struct {
+	// MaxConsecutiveErrors is the number of consecutive failures after
+	// which the watcher gives up. Defaults to 5 when left zero, so a
+	// transient failure is retried for about 15s; one means no
+	// retries.
+	MaxConsecutiveErrors int
+
+	// InitialBackoff is the wait before the first retry; it doubles on
+	// every further failure. Defaults to 1s when left zero.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the retry wait. Defaults to 1m when left zero.
+	MaxBackoff time.Duration
+
+	// OnQueryError, if set, is called from the watcher goroutine on
+	// every failed query with the number of consecutive failures so
+	// far, including the one being reported.
+	OnQueryError func(metric string, err error, consecutive int)
+}
+
+func (p QueryErrorPolicy) validate() error {
+	if p.MaxConsecutiveErrors < 0 || p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		return ErrInvalidQueryErrorPolicy
+	}
+	return nil
+}
+
+// withDefaults fills the zero fields of p.
+func (p QueryErrorPolicy) withDefaults() QueryErrorPolicy {
+	if p.MaxConsecutiveErrors == 0 {
+		p.MaxConsecutiveErrors = defaultQueryMaxConsecutiveErrors
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = defaultQueryRetryInitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = defaultQueryRetryMaxBackoff
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	return p
+}
+
+// OverflowPolicy is what a full ReportQueue does with a new report.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the new report.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued report to make room.
+	OverflowDropOldest
+	// OverflowBlock applies backpressure: the watcher waits for room.
+	OverflowBlock
+)
+
+// ReportQueue configures asynchronous reporting. The breaching
+// metric is still collected on its watcher; only its Reporter call is
+// queued. The cascade of a breach, and the rule collections, run on
+// their own goroutine, Collect calls included, so they don't hold up
+// the triggering watcher either. Capture always reports
+// synchronously.
+type ReportQueue struct {
+	// Size is the number of reports that can wait for a worker. Zero
+	// disables the queue.
+	Size int
+
+	// Workers is the number of goroutines calling Reporter.Report.
+	// Defaults to 1 when left zero.
+	Workers int
+
+	// Overflow is the policy when the queue is full. Dropped reports
+	// are logged, passed to Hooks.OnError with ErrReportDropped and
+	// counted in MetricStatus.ReportsDropped.
+	Overflow OverflowPolicy
+
+	// FlushTimeout bounds how long Stop waits for queued and in-flight
+	// reports. Past it, in-flight reports are canceled and the rest
+	// are dropped. Defaults to 30s when left zero.
+	FlushTimeout time.Duration
+}
+
+func (q ReportQueue) validate() error {
+	if q.Size < 0 || q.Workers < 0 || q.FlushTimeout < 0 ||
+		q.Overflow < OverflowDropNewest || q.Overflow > OverflowBlock {
+		return ErrInvalidReportQueue
+	}
+	return nil
+}
+
+// withDefaults fills the zero fields of q.
+func (q ReportQueue) withDefaults() ReportQueue {
+	if q.Workers == 0 {
+		q.Workers = defaultReportQueueWorkers
+	}
+	if q.FlushTimeout == 0 {
+		q.FlushTimeout = defaultReportQueueFlushTimeout
+	}
+	return q
+}
+
+// SpoolOption configures the on-disk spool of undeliverable reports.
+// Each failed report's payload and ReportInfo are written to Dir; a
+// background loop redelivers them, oldest first, every RetryInterval,
+// and ReplaySpool does so on demand. Delivered entries are deleted.
+type SpoolOption struct {
+	// Dir is the spool directory, created if missing. Empty disables
+	// the spool. Instances must not share a directory.
+	Dir string
+
+	// MaxBytes caps the total payload size on disk; the oldest entries
+	// are evicted beyond it. Defaults to 64 MiB when left zero.
+	MaxBytes int64
+
+	// MaxAge is how long an entry is kept undelivered. Defaults to 24h
+	// when left zero.
+	MaxAge time.Duration
+
+	// RetryInterval is how often the background redelivery runs.
+	// Defaults to 1m when left zero.
+	RetryInterval time.Duration
+}
+
+func (s SpoolOption) validate() error {
+	if s.MaxBytes < 0 || s.MaxAge < 0 || s.RetryInterval < 0 {
+		return ErrInvalidSpool
+	}
+	return nil
+}
+
+// withDefaults fills the zero fields of s.
+func (s SpoolOption) withDefaults() SpoolOption {
+	if s.MaxBytes == 0 {
+		s.MaxBytes = defaultSpoolMaxBytes
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = defaultSpoolMaxAge
+	}
+	if s.RetryInterval == 0 {
+		s.RetryInterval = defaultSpoolRetryInterval
+	}
+	return s
 }
 
 func (o Option) validate() error {
@@ -70,18 +362,52 @@ func (o Option) validate() error {
 	if o.DisableCPUProf && o.DisableMemProf && o.DisableGoroutineProf && len(o.Metrics) == 0 {
 		return ErrDisableAllProfiling
 	}
-	if o.CPUThreshold < 0 || o.CPUThreshold > 1 {
-		return ErrInvalidCPUThreshold
+	if err := o.validateBuiltins(); err != nil {
+		return err
 	}
-	if o.MemThreshold < 0 || o.MemThreshold > 1 {
-		return ErrInvalidMemThreshold
+	if o.WatchInterval < 0 {
+		return ErrInvalidWatchInterval
 	}
-	if o.GoroutineThreshold < 0 {
-		return ErrInvalidGoroutineThreshold
+	if o.CPUProfilingDuration < 0 {
+		return ErrInvalidCPUProfilingDuration
+	}
+	if o.MinConsecutiveOverThreshold < 0 {
+		return ErrInvalidMinConsecutiveOverThreshold
+	}
+	if o.CPUUsageWindow < 0 {
+		return ErrInvalidCPUUsageWindow
+	}
+	ruleNames := make(map[string]bool, len(o.Rules))
+	for _, r := range o.Rules {
+		if !r.validate() || ruleNames[r.Name] {
+			return ErrInvalidRule
+		}
+		ruleNames[r.Name] = true
+	}
+	if err := o.Cascade.validate(); err != nil {
+		return err
+	}
+	if o.Cooldown < 0 || o.GlobalCooldown < 0 {
+		return ErrInvalidCooldown
 	}
 	if o.ReportTimeout < 0 {
 		return ErrInvalidReportTimeout
 	}
+	if err := o.ReportQueue.validate(); err != nil {
+		return err
+	}
+	if _, err := parseTemplate("CommentTemplate", o.CommentTemplate); err != nil {
+		return err
+	}
+	if _, err := parseTemplate("FilenameTemplate", o.FilenameTemplate); err != nil {
+		return err
+	}
+	if err := o.Spool.validate(); err != nil {
+		return err
+	}
+	if err := o.QueryErrorPolicy.validate(); err != nil {
+		return err
+	}
 	if o.Reporter == nil {
 		return ErrNilReporter
 	}
@@ -93,3 +419,32 @@ func (o Option) validate() error {
 	}
 	return nil
 }
+
+// validateBuiltins checks the built-in fields that Reconfigure
+// applies.
+func (o Option) validateBuiltins() error {
+	if o.CPUThreshold < 0 || o.CPUThreshold > 1 {
+		return ErrInvalidCPUThreshold
+	}
+	if o.MemThreshold < 0 || o.MemThreshold > 1 {
+		return ErrInvalidMemThreshold
+	}
+	if o.GoroutineThreshold < 0 {
+		return ErrInvalidGoroutineThreshold
+	}
+	if !validReArm(o.CPUReArmThreshold, o.CPUThreshold, defaultCPUThreshold) ||
+		!validReArm(o.MemReArmThreshold, o.MemThreshold, defaultMemThreshold) ||
+		!validReArm(float64(o.GoroutineReArmThreshold), float64(o.GoroutineThreshold), defaultGoroutineThreshold) {
+		return ErrInvalidReArmThreshold
+	}
+	if !o.CPUSustain.validate() || !o.MemSustain.validate() || !o.GoroutineSustain.validate() {
+		return ErrInvalidSustain
+	}
+	if !o.MemRate.validate() || !o.GoroutineRate.validate() {
+		return ErrInvalidRate
+	}
+	if !o.CPUAnomaly.validate() || !o.MemAnomaly.validate() || !o.GoroutineAnomaly.validate() {
+		return ErrInvalidAnomaly
+	}
+	return nil
+}
@@ -29,12 +29,17 @@ type defaultProfiler struct {
 	// process-wide singleton — concurrent invocations would make the
 	// second one fail immediately. With ReportAll a cascade path can
 	// land on CPU at the same tick as its own watcher, so we gate it.
-	cpuMu sync.Mutex
+	// It points at processCPUMu so independent Profilers share it.
+	cpuMu *sync.Mutex
 }
 
+// processCPUMu is shared by every defaultProfiler in the process.
+var processCPUMu sync.Mutex
+
 func newDefaultProfiler(duration time.Duration) *defaultProfiler {
 	return &defaultProfiler{
 		cpuProfilingDuration: duration,
+		cpuMu:                &processCPUMu,
 	}
 }
 
@@ -34,9 +34,9 @@ type cgroupV1 struct {
 	q cpuUsageSnapshotQueuer
 }
 
-func newCgroupsV1() *cgroupV1 {
+func newCgroupsV1(queueSize int) *cgroupV1 {
 	q := newCPUUsageSnapshotQueue(
-		cpuUsageSnapshotQueueSize,
+		queueSize,
 	)
 	return &cgroupV1{
 		staticPath:   "/",
@@ -15,7 +15,7 @@ func TestCgroupV1_CPUUsage(t *testing.T) {
 	if mode != cgroups.Legacy {
 		t.Skip("cgroup v1 is not available")
 	}
-	cgv1 := newCgroupsV1()
+	cgv1 := newCgroupsV1(cpuUsageSnapshotQueueSize)
 	cgv1.cpuQuota = 2
 	cgv1.q = newCPUUsageSnapshotQueue(3)
 
@@ -53,7 +53,7 @@ func TestCgroupV1_MemUsage(t *testing.T) {
 	if mode != cgroups.Legacy {
 		t.Skip("cgroup v1 is not available")
 	}
-	usage, err := newCgroupsV1().MemUsage()
+	usage, err := newCgroupsV1(cpuUsageSnapshotQueueSize).MemUsage()
 	if err != nil {
 		t.Errorf("MemUsage() = %v, want nil", err)
 	}
@@ -67,7 +67,7 @@ func TestCgroupV1_SetCPUQuota(t *testing.T) {
 	if mode != cgroups.Legacy {
 		t.Skip("cgroup v1 is not available")
 	}
-	cgv1 := newCgroupsV1()
+	cgv1 := newCgroupsV1(cpuUsageSnapshotQueueSize)
 	if err := cgv1.SetCPUQuota(); err != nil {
 		t.Errorf("SetCPUQuota() = %v, want nil", err)
 	}
@@ -38,9 +38,9 @@ type cgroupV2 struct {
 	q cpuUsageSnapshotQueuer
 }
 
-func newCgroupsV2() *cgroupV2 {
+func newCgroupsV2(queueSize int) *cgroupV2 {
 	q := newCPUUsageSnapshotQueue(
-		cpuUsageSnapshotQueueSize,
+		queueSize,
 	)
 	return &cgroupV2{
 		groupPath:  "",
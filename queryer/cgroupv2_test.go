@@ -15,7 +15,7 @@ func TestCgroupV2_CPUUsage(t *testing.T) {
 	if mode != cgroups.Hybrid && mode != cgroups.Unified {
 		t.Skip("cgroup v2 is not available")
 	}
-	cgv2 := newCgroupsV2()
+	cgv2 := newCgroupsV2(cpuUsageSnapshotQueueSize)
 	cgv2.cpuQuota = 2
 	cgv2.q = newCPUUsageSnapshotQueue(3)
 
@@ -53,7 +53,7 @@ func TestCgroupV2_MemUsage(t *testing.T) {
 	if mode != cgroups.Hybrid && mode != cgroups.Unified {
 		t.Skip("cgroup v2 is not available")
 	}
-	cgv2 := newCgroupsV2()
+	cgv2 := newCgroupsV2(cpuUsageSnapshotQueueSize)
 	usage, err := cgv2.MemUsage()
 	if err != nil {
 		t.Errorf("MemUsage() = %v, want nil", err)
@@ -68,7 +68,7 @@ func TestCgroupV2_SetCPUQuota(t *testing.T) {
 	if mode != cgroups.Hybrid && mode != cgroups.Unified {
 		t.Skip("cgroup v2 is not available")
 	}
-	cgv2 := newCgroupsV2()
+	cgv2 := newCgroupsV2(cpuUsageSnapshotQueueSize)
 	if err := cgv2.SetCPUQuota(); err != nil {
 		t.Errorf("SetCPUQuota() = %v, want nil", err)
 	}
@@ -11,6 +11,10 @@ import (
 
 const (
 	cpuUsageSnapshotQueueSize = 24 // 24 * 5s = 2 minutes.
+
+	// minCPUUsageSnapshotQueueSize is the fewest snapshots a usage can
+	// be computed from: the head and the tail.
+	minCPUUsageSnapshotQueueSize = 2
 )
 
 type CgroupsQueryer interface {
@@ -25,11 +29,22 @@ type RuntimeQueryer interface {
 }
 
 func NewCgroupQueryer() (CgroupsQueryer, error) {
+	return NewCgroupQueryerWithWindow(cpuUsageSnapshotQueueSize)
+}
+
+// NewCgroupQueryerWithWindow is NewCgroupQueryer with a CPU usage
+// averaged over the last size CPUUsage calls instead of 24. CPUUsage
+// returns 0 until size snapshots are taken. A size below 2 is raised
+// to 2.
+func NewCgroupQueryerWithWindow(size int) (CgroupsQueryer, error) {
+	if size < minCPUUsageSnapshotQueueSize {
+		size = minCPUUsageSnapshotQueueSize
+	}
 	switch cgroups.Mode() {
 	case cgroups.Legacy:
-		return newCgroupsV1(), nil
+		return newCgroupsV1(size), nil
 	case cgroups.Hybrid, cgroups.Unified:
-		return newCgroupsV2(), nil
+		return newCgroupsV2(size), nil
 	}
 	return nil, ErrCgroupsUnavailable
 }
@@ -18,3 +18,33 @@ func TestNewCgroupQueryer(t *testing.T) {
 		t.Errorf("newQueryer() = %v, want nil", err)
 	}
 }
+
+func TestNewCgroupQueryerWithWindow(t *testing.T) {
+	if cgroups.Mode() == cgroups.Unavailable {
+		t.Skip("cgroups unavailable")
+	}
+	testCases := []struct {
+		size int
+		want int
+	}{
+		{size: 0, want: minCPUUsageSnapshotQueueSize},
+		{size: 1, want: minCPUUsageSnapshotQueueSize},
+		{size: 60, want: 60},
+	}
+	for _, tc := range testCases {
+		qryer, err := NewCgroupQueryerWithWindow(tc.size)
+		if err != nil {
+			t.Fatalf("NewCgroupQueryerWithWindow(%d) = %v, want nil", tc.size, err)
+		}
+		var q cpuUsageSnapshotQueuer
+		switch c := qryer.(type) {
+		case *cgroupV1:
+			q = c.q
+		case *cgroupV2:
+			q = c.q
+		}
+		if got := cap(q.(*cpuUsageSnapshotQueue).list); got != tc.want {
+			t.Errorf("NewCgroupQueryerWithWindow(%d) queue size = %d, want %d", tc.size, got, tc.want)
+		}
+	}
+}
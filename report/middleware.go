@@ -0,0 +1,140 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrPayloadTooLarge is returned by the MaxSize middleware for a
+// payload above its limit.
+var ErrPayloadTooLarge = errors.New("autopprof: report payload is too large")
+
+// Middleware wraps a Reporter to filter, enrich or transform the
+// reports on their way to it.
+type Middleware func(Reporter) Reporter
+
+// Chain wraps r with mws. The first middleware is the outermost: it
+// sees each report first and the result last.
+func Chain(r Reporter, mws ...Middleware) Reporter {
+	for i := len(mws) - 1; i >= 0; i-- {
+		r = mws[i](r)
+	}
+	return r
+}
+
+// Filter drops the reports keep returns false for. A dropped report
+// is not an error.
+func Filter(keep func(info ReportInfo) bool) Middleware {
+	return func(next Reporter) Reporter {
+		return ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+			if !keep(info) {
+				return nil
+			}
+			return next.Report(ctx, r, info)
+		})
+	}
+}
+
+// DropMetrics drops the reports of the named metrics.
+func DropMetrics(names ...string) Middleware {
+	drop := make(map[string]bool, len(names))
+	for _, name := range names {
+		drop[name] = true
+	}
+	return Filter(func(info ReportInfo) bool { return !drop[info.MetricName] })
+}
+
+// MinValue drops the reports whose Value is below min.
+func MinValue(min float64) Middleware {
+	return Filter(func(info ReportInfo) bool { return info.Value >= min })
+}
+
+// AppendComment adds the line returned by line to the Comment, on a
+// new line. An empty line leaves the Comment as is.
+func AppendComment(line func(info ReportInfo) string) Middleware {
+	return func(next Reporter) Reporter {
+		return ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+			if l := line(info); l != "" {
+				if info.Comment == "" {
+					info.Comment = l
+				} else {
+					info.Comment += "\n" + l
+				}
+			}
+			return next.Report(ctx, r, info)
+		})
+	}
+}
+
+// RenameFile replaces the Filename with the one rename returns.
+func RenameFile(rename func(info ReportInfo) string) Middleware {
+	return func(next Reporter) Reporter {
+		return ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+			info.Filename = rename(info)
+			return next.Report(ctx, r, info)
+		})
+	}
+}
+
+// MaxSize rejects payloads larger than n bytes with
+// ErrPayloadTooLarge instead of sending them. Truncating would corrupt
+// a profile.
+func MaxSize(n int64) Middleware {
+	return func(next Reporter) Reporter {
+		return ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+			data, err := io.ReadAll(io.LimitReader(r, n+1))
+			if err != nil {
+				return fmt.Errorf("autopprof: failed to read the payload: %w", err)
+			}
+			if int64(len(data)) > n {
+				return fmt.Errorf("%w: %s exceeds %d bytes", ErrPayloadTooLarge, info.Filename, n)
+			}
+			return next.Report(ctx, bytes.NewReader(data), info)
+		})
+	}
+}
+
+// Timeout bounds every Report call by d, on top of the ctx deadline.
+func Timeout(d time.Duration) Middleware {
+	return func(next Reporter) Reporter {
+		return ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next.Report(ctx, r, info)
+		})
+	}
+}
+
+// Logger is the part of autopprof.Logger that Log uses. A
+// *slog.Logger satisfies it.
+type Logger interface {
+	Info(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Log logs every report with its outcome and duration.
+func Log(logger Logger) Middleware {
+	return func(next Reporter) Reporter {
+		return ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+			begin := time.Now()
+			err := next.Report(ctx, r, info)
+			args := []any{
+				"metric", info.MetricName,
+				"filename", info.Filename,
+				"value", info.Value,
+				"threshold", info.Threshold,
+				"duration", time.Since(begin),
+			}
+			if err != nil {
+				logger.Error("autopprof: report failed", append(args, "error", err)...)
+			} else {
+				logger.Info("autopprof: report sent", args...)
+			}
+			return err
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChain_order(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next Reporter) Reporter {
+			return ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+				order = append(order, name)
+				return next.Report(ctx, r, info)
+			})
+		}
+	}
+	dest := &recordReporter{}
+	if err := Chain(dest, mw("a"), mw("b")).Report(context.Background(), strings.NewReader("x"), ReportInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "a,b" || len(dest.infos) != 1 {
+		t.Errorf("order = %v, reports = %d", order, len(dest.infos))
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	base := ReportInfo{MetricName: "mem", Filename: "heap.pprof", Comment: "mem high", Value: 0.8}
+	testCases := []struct {
+		name     string
+		mw       Middleware
+		slow     bool // the destination waits for ctx to be done
+		payload  string
+		wantSent bool
+		wantErr  error
+		check    func(t *testing.T, info ReportInfo, payload string)
+	}{
+		{
+			name:     "drop metrics",
+			mw:       DropMetrics("goroutine", "mem"),
+			wantSent: false,
+		},
+		{
+			name:     "drop metrics keeps others",
+			mw:       DropMetrics("cpu"),
+			wantSent: true,
+		},
+		{
+			name:     "min value",
+			mw:       MinValue(0.9),
+			wantSent: false,
+		},
+		{
+			name:     "append comment",
+			mw:       AppendComment(func(info ReportInfo) string { return "pod: web-1" }),
+			wantSent: true,
+			check: func(t *testing.T, info ReportInfo, _ string) {
+				if info.Comment != "mem high\npod: web-1" {
+					t.Errorf("Comment = %q", info.Comment)
+				}
+			},
+		},
+		{
+			name:     "rename file",
+			mw:       RenameFile(func(info ReportInfo) string { return "prod-" + info.Filename }),
+			wantSent: true,
+			check: func(t *testing.T, info ReportInfo, _ string) {
+				if info.Filename != "prod-heap.pprof" {
+					t.Errorf("Filename = %q", info.Filename)
+				}
+			},
+		},
+		{
+			name:     "max size within limit",
+			mw:       MaxSize(5),
+			payload:  "12345",
+			wantSent: true,
+			check: func(t *testing.T, _ ReportInfo, payload string) {
+				if payload != "12345" {
+					t.Errorf("payload = %q", payload)
+				}
+			},
+		},
+		{
+			name:     "max size exceeded",
+			mw:       MaxSize(5),
+			payload:  "123456",
+			wantSent: false,
+			wantErr:  ErrPayloadTooLarge,
+		},
+		{
+			name:     "timeout",
+			mw:       Timeout(time.Millisecond),
+			slow:     true,
+			wantSent: true,
+			wantErr:  context.DeadlineExceeded,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := &recordReporter{}
+			next := Reporter(dest)
+			if tc.slow {
+				next = ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+					dest.Report(ctx, r, info)
+					<-ctx.Done()
+					return ctx.Err()
+				})
+			}
+			payload := tc.payload
+			if payload == "" {
+				payload = "x"
+			}
+			err := tc.mw(next).Report(context.Background(), strings.NewReader(payload), base)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Report() = %v, want %v", err, tc.wantErr)
+			}
+			if sent := len(dest.infos) == 1; sent != tc.wantSent {
+				t.Fatalf("sent = %v, want %v", sent, tc.wantSent)
+			}
+			if tc.check != nil {
+				tc.check(t, dest.infos[0], dest.payloads[0])
+			}
+		})
+	}
+}
+
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) Info(msg string, args ...any) {
+	l.lines = append(l.lines, fmt.Sprint(append([]any{"INFO ", msg}, args...)...))
+}
+
+func (l *recordLogger) Error(msg string, args ...any) {
+	l.lines = append(l.lines, fmt.Sprint(append([]any{"ERROR ", msg}, args...)...))
+}
+
+func TestLog(t *testing.T) {
+	logger := &recordLogger{}
+	r := Chain(&recordReporter{err: errors.New("down")}, Log(logger))
+	if err := r.Report(context.Background(), strings.NewReader("x"), ReportInfo{MetricName: "cpu"}); err == nil {
+		t.Fatal("Report() = nil, want the destination error")
+	}
+	if len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], "ERROR autopprof: report failed") ||
+		!strings.Contains(logger.lines[0], "cpu") {
+		t.Errorf("unexpected log lines: %q", logger.lines)
+	}
+}
@@ -0,0 +1,113 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MultiReporter fans every report out to several Reporters.
+type MultiReporter struct {
+	reporters []Reporter
+}
+
+// Multi returns a Reporter that sends every report to all reporters
+// concurrently. The payload is read once and each destination gets its
+// own reader over it. Nil reporters are skipped.
+func Multi(reporters ...Reporter) *MultiReporter {
+	return &MultiReporter{
+		reporters: append([]Reporter(nil), reporters...),
+	}
+}
+
+// Report sends the report to every destination and waits for all of
+// them. It returns nil if all succeed, or a MultiError holding a
+// *DestinationError per failed destination.
+func (m *MultiReporter) Report(
+	ctx context.Context, r io.Reader, info ReportInfo,
+) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("autopprof: failed to read the payload: %w", err)
+	}
+
+	errs := make([]error, len(m.reporters))
+	var wg sync.WaitGroup
+	for i, dest := range m.reporters {
+		if dest == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, dest Reporter) {
+			defer wg.Done()
+			if err := dest.Report(ctx, bytes.NewReader(data), info); err != nil {
+				errs[i] = &DestinationError{Index: i, Err: err}
+			}
+		}(i, dest)
+	}
+	wg.Wait()
+
+	var failed MultiError
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return failed
+}
+
+// DestinationError is the failure of one destination of Multi.
+type DestinationError struct {
+	// Index is the position of the destination in the Multi call,
+	// nil reporters included.
+	Index int
+	Err   error
+}
+
+func (e *DestinationError) Error() string {
+	return fmt.Sprintf("destination %d: %v", e.Index, e.Err)
+}
+
+func (e *DestinationError) Unwrap() error { return e.Err }
+
+// MultiError aggregates the failures of several destinations.
+// errors.Is and errors.As see every wrapped error, on Go versions
+// before 1.20 too.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return "autopprof: " + strings.Join(msgs, "; ")
+}
+
+func (m MultiError) Unwrap() []error { return m }
+
+// Is reports whether any wrapped error matches target.
+func (m MultiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first wrapped error that matches target.
+func (m MultiError) As(target any) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordReporter records the payloads it receives, failing with err.
+type recordReporter struct {
+	mu       sync.Mutex
+	payloads []string
+	infos    []ReportInfo
+	err      error
+}
+
+func (r *recordReporter) Report(_ context.Context, reader io.Reader, info ReportInfo) error {
+	b, _ := io.ReadAll(reader)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.payloads = append(r.payloads, string(b))
+	r.infos = append(r.infos, info)
+	return r.err
+}
+
+func TestMulti(t *testing.T) {
+	errDown := errors.New("down")
+	a, b, c := &recordReporter{}, &recordReporter{err: errDown}, &recordReporter{}
+
+	err := Multi(a, nil, b, c).Report(context.Background(), strings.NewReader("payload"), ReportInfo{MetricName: "cpu"})
+
+	for i, r := range []*recordReporter{a, b, c} {
+		if len(r.payloads) != 1 || r.payloads[0] != "payload" {
+			t.Errorf("destination %d got %q, want the full payload once", i, r.payloads)
+		}
+	}
+	var me MultiError
+	if !errors.As(err, &me) || len(me) != 1 {
+		t.Fatalf("Report() = %v, want a MultiError with one failure", err)
+	}
+	var de *DestinationError
+	if !errors.As(me[0], &de) || de.Index != 2 || !errors.Is(err, errDown) {
+		t.Errorf("unexpected destination error: %v", me[0])
+	}
+	if de = nil; !errors.As(err, &de) || de.Index != 2 {
+		t.Errorf("errors.As(%v) = %v, want destination 2", err, de)
+	}
+
+	if err := Multi(a, c).Report(context.Background(), strings.NewReader("x"), ReportInfo{}); err != nil {
+		t.Errorf("Report() = %v, want nil", err)
+	}
+}
+
+func TestRouteRules(t *testing.T) {
+	perf, sre, critical := &recordReporter{}, &recordReporter{}, &recordReporter{}
+	r := RouteRules(
+		RouteRule{MetricName: "cpu", Reporter: perf},
+		RouteRule{MetricName: "mem", MinValue: 0.95, Reporter: critical},
+		RouteRule{MetricName: "mem", Reporter: sre},
+	)
+	for _, info := range []ReportInfo{
+		{MetricName: "cpu", Value: 0.8},
+		{MetricName: "mem", Value: 0.8},
+		{MetricName: "mem", Value: 0.99},
+		{MetricName: "db_pool", Value: 1}, // no rule: dropped
+	} {
+		if err := r.Report(context.Background(), strings.NewReader("x"), info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(perf.infos) != 1 || len(sre.infos) != 1 || len(critical.infos) != 1 ||
+		critical.infos[0].Value != 0.99 {
+		t.Errorf("unexpected routing: perf=%v sre=%v critical=%v", perf.infos, sre.infos, critical.infos)
+	}
+}
+
+func TestRoute_nilDrops(t *testing.T) {
+	r := Route(func(ReportInfo) Reporter { return nil })
+	if err := r.Report(context.Background(), strings.NewReader("x"), ReportInfo{}); err != nil {
+		t.Errorf("Report() = %v, want nil", err)
+	}
+}
@@ -3,10 +3,23 @@ package report
 import (
 	"context"
 	"io"
+	"time"
 )
 
 //go:generate mockgen -source=report.go -destination=report_mock.go -package=report
 
+// Triggers of ReportInfo.Trigger.
+const (
+	// TriggerThreshold is a value at or past the threshold.
+	TriggerThreshold = "threshold"
+	// TriggerRate is a value changing faster than the metric's Rate.
+	TriggerRate = "rate"
+	// TriggerAnomaly is a value departing from the metric's baseline.
+	TriggerAnomaly = "anomaly"
+	// TriggerRule is a rule's condition over several metrics holding.
+	TriggerRule = "rule"
+)
+
 // ReportInfo carries structured metadata about a report so Reporter
 // implementations can route or re-format without parsing filenames
 // or comments.
@@ -30,6 +43,68 @@ type ReportInfo struct {
 
 	// Threshold is the Metric's configured threshold.
 	Threshold float64
+
+	// Direction is "above" when the metric breaches at or above
+	// Threshold, "below" when it breaches at or below it.
+	Direction string
+
+	// Trigger is why the incident fired: TriggerThreshold,
+	// TriggerRate, TriggerAnomaly or TriggerRule. It is empty for
+	// on-demand captures.
+	Trigger string
+
+	// Condition is, for a TriggerRule incident, the rule's condition,
+	// e.g. "cpu >= 0.7 AND goroutine >= 10000". TriggeredBy is the
+	// rule's name.
+	Condition string
+
+	// Change is, for the breaching report of a TriggerRate incident,
+	// how much the value changed within the Rate window.
+	Change float64
+
+	// Baseline and Deviation are, for the breaching report of a
+	// TriggerAnomaly incident, the EWMA mean before the value and the
+	// value's distance from it in standard deviations.
+	Baseline  float64
+	Deviation float64
+
+	// IncidentID is shared by the report of a breach and the reports of
+	// its cascade, and by the reports of one CaptureAll, so Reporters
+	// can group them, e.g. into one Slack thread or storage folder.
+	IncidentID string
+
+	// TriggeredBy is the metric whose breach caused the report: the
+	// metric itself for the breaching report, the breaching one for its
+	// cascade. It is empty for on-demand captures.
+	TriggeredBy string
+
+	// IsCascade is true for the reports collected because another
+	// metric breached.
+	IsCascade bool
+
+	// StartTime and EndTime bound the capture: a CPU profile spans the
+	// profiling duration, a snapshot only the time it took.
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Hostname and PID identify the process the report comes from.
+	Hostname string
+	PID      int
+
+	// App is autopprof.Option.App.
+	App string
+
+	// Labels are autopprof.Option.Labels, e.g. env or region. Each
+	// report gets its own copy.
+	Labels map[string]string
+
+	// GoVersion is the Go toolchain that built the binary.
+	GoVersion string
+
+	// BuildVersion and BuildRevision are the main module's version and
+	// VCS revision from debug.ReadBuildInfo, empty when unknown.
+	BuildVersion  string
+	BuildRevision string
 }
 
 // Reporter sends a single profile/payload to its destination. Every
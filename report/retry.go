@@ -0,0 +1,194 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// RetryOption is the retry policy of WithRetry.
+type RetryOption struct {
+	// MaxAttempts is the number of Report calls, the first one
+	// included. Defaults to 3 when left zero.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry; it doubles on
+	// every further one. Defaults to 1s when left zero.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait. Defaults to 30s when left zero.
+	MaxBackoff time.Duration
+
+	// Jitter randomizes every wait by up to this fraction of it (0 to
+	// 1), so instances failing together don't retry in lockstep. Zero
+	// disables it.
+	Jitter float64
+
+	// IsRetryable decides whether a failed attempt is retried.
+	// Defaults to IsRetryable when left nil.
+	IsRetryable func(err error) bool
+
+	// OnRetry, if set, is called before every retry with the number of
+	// the attempt that failed and its error.
+	OnRetry func(info ReportInfo, attempt int, err error)
+}
+
+func (o RetryOption) withDefaults() RetryOption {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if o.MaxBackoff < o.InitialBackoff {
+		o.MaxBackoff = o.InitialBackoff
+	}
+	if o.Jitter < 0 {
+		o.Jitter = 0
+	} else if o.Jitter > 1 {
+		o.Jitter = 1
+	}
+	if o.IsRetryable == nil {
+		o.IsRetryable = IsRetryable
+	}
+	return o
+}
+
+// RetryableError marks Err as transient, worth another attempt.
+// Reporters return it to opt an error into WithRetry's retries.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string   { return e.Err.Error() }
+func (e *RetryableError) Unwrap() error   { return e.Err }
+func (e *RetryableError) Temporary() bool { return true }
+
+// IsRetryable reports whether err, or an error it wraps, is transient:
+// a RetryableError, or an error with a Temporary() or Retryable()
+// method returning true, the convention of net errors and of the Slack
+// client's 5xx and rate-limit errors. Context errors never are.
+func IsRetryable(err error) bool {
+	if err == nil ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var retryable interface{ Retryable() bool }
+	return errors.As(err, &retryable) && retryable.Retryable()
+}
+
+// RetryReporter is a Reporter that retries the failed calls of
+// another one.
+type RetryReporter struct {
+	reporter Reporter
+	opt      RetryOption
+
+	// randMu guards rand, seeded per reporter: the global source of
+	// math/rand starts from the same seed in every process before Go
+	// 1.20, which would put instances back in lockstep.
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// WithRetry wraps r so that retryable errors are retried with
+// exponential backoff. The payload is rewound between attempts: a
+// reader that is an io.Seeker is seeked back, any other is buffered in
+// memory first. The ctx passed to Report bounds every attempt and
+// wait, so leave room for them in autopprof's Option.ReportTimeout.
+func WithRetry(r Reporter, opt RetryOption) *RetryReporter {
+	return &RetryReporter{
+		reporter: r,
+		opt:      opt.withDefaults(),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Report calls the wrapped Reporter until it succeeds, fails with a
+// non-retryable error, runs out of attempts or ctx is done. It returns
+// the last error.
+func (r *RetryReporter) Report(
+	ctx context.Context, reader io.Reader, info ReportInfo,
+) error {
+	rewind, err := rewinder(reader)
+	if err != nil {
+		return err
+	}
+	backoff := r.opt.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		body, err := rewind()
+		if err != nil {
+			return err
+		}
+		err = r.reporter.Report(ctx, body, info)
+		if err == nil || attempt >= r.opt.MaxAttempts || !r.opt.IsRetryable(err) {
+			return err
+		}
+		if r.opt.OnRetry != nil {
+			r.opt.OnRetry(info, attempt, err)
+		}
+
+		timer := time.NewTimer(r.jittered(backoff))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+		if backoff > r.opt.MaxBackoff {
+			backoff = r.opt.MaxBackoff
+		}
+	}
+}
+
+func (r *RetryReporter) jittered(d time.Duration) time.Duration {
+	if r.opt.Jitter == 0 {
+		return d
+	}
+	r.randMu.Lock()
+	f := r.rand.Float64()
+	r.randMu.Unlock()
+	return d - time.Duration(r.opt.Jitter*f*float64(d))
+}
+
+// rewinder returns a func yielding reader from its start position on
+// every call.
+func rewinder(reader io.Reader) (func() (io.Reader, error), error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("autopprof: failed to locate the payload start: %w", err)
+		}
+		return func() (io.Reader, error) {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("autopprof: failed to rewind the payload: %w", err)
+			}
+			return seeker, nil
+		}, nil
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("autopprof: failed to buffer the payload: %w", err)
+	}
+	return func() (io.Reader, error) {
+		return bytes.NewReader(data), nil
+	}, nil
+}
@@ -0,0 +1,149 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/slack-go/slack"
+)
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain", errors.New("boom"), false},
+		{"RetryableError", &RetryableError{Err: errors.New("boom")}, true},
+		{"wrapped RetryableError", fmt.Errorf("upload: %w", &RetryableError{Err: errors.New("boom")}), true},
+		{"slack 503", fmt.Errorf("upload: %w", slack.StatusCodeError{Code: 503}), true},
+		{"slack 400", slack.StatusCodeError{Code: 400}, false},
+		{"slack rate limited", &slack.RateLimitedError{RetryAfter: time.Second}, true},
+		{"deadline", context.DeadlineExceeded, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsRetryable(tc.err); got != tc.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	transient := &RetryableError{Err: errors.New("503")}
+	permanent := errors.New("400")
+	testCases := []struct {
+		name      string
+		errs      []error // returned by the attempts, in order
+		reader    func() io.Reader
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "succeeds after transient failures",
+			errs:      []error{transient, transient, nil},
+			wantCalls: 3,
+		},
+		{
+			name:      "gives up after max attempts",
+			errs:      []error{transient, transient, transient, nil},
+			wantCalls: 3,
+			wantErr:   transient,
+		},
+		{
+			name:      "permanent error is not retried",
+			errs:      []error{permanent, nil},
+			wantCalls: 1,
+			wantErr:   permanent,
+		},
+		{
+			name:      "non-seekable reader is buffered",
+			errs:      []error{transient, nil},
+			reader:    func() io.Reader { return io.MultiReader(strings.NewReader("payload")) },
+			wantCalls: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				calls   int
+				retries []int
+			)
+			mock := NewMockReporter(gomock.NewController(t))
+			mock.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+				AnyTimes().
+				DoAndReturn(func(_ context.Context, r io.Reader, _ ReportInfo) error {
+					b, _ := io.ReadAll(r)
+					if string(b) != "payload" {
+						t.Errorf("attempt %d read %q, want the full payload", calls+1, b)
+					}
+					err := tc.errs[calls]
+					calls++
+					return err
+				})
+			reader := io.Reader(bytes.NewReader([]byte("payload")))
+			if tc.reader != nil {
+				reader = tc.reader()
+			}
+
+			rr := WithRetry(mock, RetryOption{
+				InitialBackoff: time.Millisecond,
+				Jitter:         0.5,
+				OnRetry:        func(_ ReportInfo, attempt int, _ error) { retries = append(retries, attempt) },
+			})
+			err := rr.Report(context.Background(), reader, ReportInfo{})
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Report() = %v, want %v", err, tc.wantErr)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tc.wantCalls)
+			}
+			if len(retries) != calls-1 {
+				t.Errorf("OnRetry called for attempts %v, want %d", retries, calls-1)
+			}
+		})
+	}
+}
+
+func TestWithRetry_ctxDone(t *testing.T) {
+	mock := NewMockReporter(gomock.NewController(t))
+	mock.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(1).
+		Return(&RetryableError{Err: errors.New("503")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rr := WithRetry(mock, RetryOption{InitialBackoff: time.Hour})
+	begin := time.Now()
+	if err := rr.Report(ctx, bytes.NewReader(nil), ReportInfo{}); err == nil {
+		t.Error("Report() = nil, want the last error")
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("Report() waited %v past ctx", elapsed)
+	}
+}
+
+func TestWithRetry_jitterPerReporter(t *testing.T) {
+	opt := RetryOption{Jitter: 1}
+	a, b := WithRetry(nil, opt), WithRetry(nil, opt)
+	same := true
+	for i := 0; i < 5; i++ {
+		da, db := a.jittered(time.Second), b.jittered(time.Second)
+		if da < 0 || da > time.Second {
+			t.Fatalf("jittered(1s) = %v, want within [0, 1s]", da)
+		}
+		same = same && da == db
+	}
+	if same {
+		t.Error("two reporters drew the same jitter sequence")
+	}
+}
@@ -0,0 +1,58 @@
+package report
+
+import (
+	"context"
+	"io"
+)
+
+// ReporterFunc adapts a function to the Reporter interface.
+type ReporterFunc func(ctx context.Context, r io.Reader, info ReportInfo) error
+
+// Report calls f.
+func (f ReporterFunc) Report(ctx context.Context, r io.Reader, info ReportInfo) error {
+	return f(ctx, r, info)
+}
+
+// Route returns a Reporter that sends each report to the Reporter pick
+// returns for it. A nil pick result drops the report.
+func Route(pick func(info ReportInfo) Reporter) Reporter {
+	return ReporterFunc(func(ctx context.Context, r io.Reader, info ReportInfo) error {
+		dest := pick(info)
+		if dest == nil {
+			return nil
+		}
+		return dest.Report(ctx, r, info)
+	})
+}
+
+// RouteRule matches reports for RouteRules.
+type RouteRule struct {
+	// MetricName matches ReportInfo.MetricName; empty matches any
+	// metric.
+	MetricName string
+	// MinValue, if non-zero, requires ReportInfo.Value to be at least
+	// MinValue.
+	MinValue float64
+	// Reporter receives the matching reports.
+	Reporter Reporter
+}
+
+func (r RouteRule) matches(info ReportInfo) bool {
+	return (r.MetricName == "" || r.MetricName == info.MetricName) &&
+		(r.MinValue == 0 || info.Value >= r.MinValue)
+}
+
+// RouteRules returns a Reporter that sends each report to the Reporter
+// of the first matching rule, or drops it when none matches. End the
+// table with a rule without conditions to catch the rest.
+func RouteRules(rules ...RouteRule) Reporter {
+	rules = append([]RouteRule(nil), rules...)
+	return Route(func(info ReportInfo) Reporter {
+		for _, rule := range rules {
+			if rule.matches(info) {
+				return rule.Reporter
+			}
+		}
+		return nil
+	})
+}
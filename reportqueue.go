@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// reportQueue is the bounded queue between the watchers and the
+// Reporter. Workers ship jobs until close, which flushes what is left
+// within the FlushTimeout.
+type reportQueue struct {
+	ap  *autoPprof
+	opt ReportQueue
+
+	jobs chan reportJob
+	// closingC unblocks OverflowBlock senders once close begins.
+	closingC chan struct{}
+	// mu guards closed; enqueue holds it shared so close never closes
+	// jobs under a sender.
+	mu     sync.RWMutex
+	closed bool
+
+	// ctx is the parent of every Reporter call; close cancels it when
+	// the flush times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newReportQueue(ap *autoPprof, opt ReportQueue) *reportQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &reportQueue{
+		ap:       ap,
+		opt:      opt,
+		jobs:     make(chan reportJob, opt.Size),
+		closingC: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	q.wg.Add(opt.Workers)
+	for i := 0; i < opt.Workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// enqueue queues job, applying the overflow policy when the queue is
+// full. Jobs it can't queue are dropped.
+func (q *reportQueue) enqueue(job reportJob) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.drop(job)
+		return
+	}
+	switch q.opt.Overflow {
+	case OverflowBlock:
+		select {
+		case q.jobs <- job:
+		case <-q.closingC:
+			q.drop(job)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.jobs <- job:
+				return
+			default:
+			}
+			select {
+			case old := <-q.jobs:
+				q.drop(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.jobs <- job:
+		default:
+			q.drop(job)
+		}
+	}
+}
+
+func (q *reportQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		if q.ctx.Err() != nil {
+			// The flush timed out; don't start new uploads.
+			q.drop(job)
+			continue
+		}
+		if err := q.ap.deliver(q.ctx, job); err != nil {
+			q.ap.logger.Error("autopprof: metric report failed",
+				logKeyMetric, job.info.MetricName,
+				logKeyValue, job.info.Value,
+				logKeyThreshold, job.info.Threshold,
+				logKeyPhase, phaseOf(err),
+				logKeyError, err,
+			)
+		}
+	}
+}
+
+// drop records a report that will never reach the Reporter.
+func (q *reportQueue) drop(job reportJob) {
+	job.runner.recordDrop()
+	q.ap.logger.Warn("autopprof: report dropped",
+		logKeyMetric, job.info.MetricName,
+		logKeyValue, job.info.Value,
+		logKeyThreshold, job.info.Threshold,
+		logKeyPhase, phaseReport,
+		logKeyError, ErrReportDropped,
+	)
+	event := job.event
+	event.Phase, event.Err = phaseReport, ErrReportDropped
+	callHook(q.ap.hooks.OnError, event)
+}
+
+// close stops accepting jobs and waits up to FlushTimeout for the
+// workers to ship the queued ones. Past the timeout, in-flight
+// Reporter calls are canceled, the rest are dropped, and close returns
+// without waiting for Reporters that ignore their ctx.
+func (q *reportQueue) close() {
+	close(q.closingC)
+	q.mu.Lock()
+	q.closed = true
+	close(q.jobs)
+	q.mu.Unlock()
+
+	doneC := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(doneC)
+	}()
+	timer := time.NewTimer(q.opt.FlushTimeout)
+	defer timer.Stop()
+	select {
+	case <-doneC:
+	case <-timer.C:
+		q.ap.logger.Warn("autopprof: report queue flush timed out",
+			"timeout", q.opt.FlushTimeout,
+		)
+	}
+	q.cancel()
+}
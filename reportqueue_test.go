@@ -0,0 +1,164 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daangn/autopprof/v2/report"
+	"github.com/golang/mock/gomock"
+)
+
+// newQueueJob returns a collected job for a runner named name.
+func newQueueJob(name string, value float64) reportJob {
+	r := newRunner(&fakeMetric{nameVal: name}, MetricConfig{})
+	return reportJob{
+		runner: r,
+		reader: bytes.NewReader([]byte("x")),
+		info:   report.ReportInfo{MetricName: name, Value: value},
+		event:  Event{MetricName: name, Value: value},
+	}
+}
+
+func TestReportQueue_watcherNotBlocked(t *testing.T) {
+	release := make(chan struct{})
+	var reported atomic.Int32
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(ctx context.Context, _ io.Reader, _ report.ReportInfo) error {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			reported.Add(1)
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.minConsecutiveOverThreshold = 1
+	ap.logger = &recordLogger{}
+	ap.queue = newReportQueue(ap, ReportQueue{Size: 2, Workers: 1, FlushTimeout: time.Second})
+	fm := &fakeMetric{nameVal: "slow", thresholdVal: 1, intervalVal: 5 * time.Millisecond,
+		queryFn: func() (float64, error) { return 10, nil }, collectFn: byteCollect}
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Reporter is stuck, yet the watcher keeps querying and the
+	// overflowing reports are dropped.
+	waitFor(t, func() bool { return fm.queryCalls.Load() > 10 }, time.Second)
+	st := ap.status()[0]
+	if st.ReportsDropped == 0 {
+		t.Errorf("expected dropped reports, got %+v", st)
+	}
+
+	close(release)
+	ap.stop()
+	// At least the one in flight plus the full queue are flushed.
+	if got := reported.Load(); got < 3 {
+		t.Errorf("reported %d after Stop, want at least 3", got)
+	}
+}
+
+func TestReportQueue_overflow(t *testing.T) {
+	t.Run("drop newest", func(t *testing.T) {
+		rec := &hookRecorder{}
+		ap := newTestAp(t, nil)
+		ap.logger = &recordLogger{}
+		ap.hooks = rec.hooks()
+		q := newReportQueue(ap, ReportQueue{Size: 1, FlushTimeout: time.Second})
+		first, second := newQueueJob("a", 1), newQueueJob("a", 2)
+		q.enqueue(first)
+		q.enqueue(second)
+
+		if got := (<-q.jobs).info.Value; got != 1 {
+			t.Errorf("queued value = %v, want 1", got)
+		}
+		errs := rec.get("error")
+		if len(errs) != 1 || !errors.Is(errs[0].Err, ErrReportDropped) || errs[0].Value != 2 {
+			t.Errorf("unexpected error events: %+v", errs)
+		}
+		if n := second.runner.status().ReportsDropped; n != 1 {
+			t.Errorf("ReportsDropped = %d, want 1", n)
+		}
+	})
+	t.Run("drop oldest", func(t *testing.T) {
+		ap := newTestAp(t, nil)
+		ap.logger = &recordLogger{}
+		q := newReportQueue(ap, ReportQueue{Size: 1, Overflow: OverflowDropOldest, FlushTimeout: time.Second})
+		first, second := newQueueJob("a", 1), newQueueJob("a", 2)
+		q.enqueue(first)
+		q.enqueue(second)
+
+		if got := (<-q.jobs).info.Value; got != 2 {
+			t.Errorf("queued value = %v, want 2", got)
+		}
+		if n := first.runner.status().ReportsDropped; n != 1 {
+			t.Errorf("ReportsDropped = %d, want 1", n)
+		}
+	})
+	t.Run("block", func(t *testing.T) {
+		ap := newTestAp(t, nil)
+		ap.logger = &recordLogger{}
+		q := newReportQueue(ap, ReportQueue{Size: 1, Overflow: OverflowBlock, FlushTimeout: time.Second})
+		q.enqueue(newQueueJob("a", 1))
+
+		doneC := make(chan struct{})
+		go func() {
+			q.enqueue(newQueueJob("a", 2))
+			close(doneC)
+		}()
+		select {
+		case <-doneC:
+			t.Fatal("enqueue on a full queue should block")
+		case <-time.After(20 * time.Millisecond):
+		}
+		if got := (<-q.jobs).info.Value; got != 1 {
+			t.Errorf("queued value = %v, want 1", got)
+		}
+		<-doneC
+		if got := (<-q.jobs).info.Value; got != 2 {
+			t.Errorf("queued value = %v, want 2", got)
+		}
+	})
+}
+
+func TestReportQueue_closeFlushTimeout(t *testing.T) {
+	var canceled atomic.Bool
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(ctx context.Context, _ io.Reader, _ report.ReportInfo) error {
+			<-ctx.Done()
+			canceled.Store(true)
+			return ctx.Err()
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.logger = &recordLogger{}
+	q := newReportQueue(ap, ReportQueue{Size: 4, Workers: 1, FlushTimeout: 30 * time.Millisecond})
+	for i := 0; i < 3; i++ {
+		q.enqueue(newQueueJob("a", float64(i)))
+	}
+
+	begin := time.Now()
+	q.close()
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("close took %v, want about the flush timeout", elapsed)
+	}
+	waitFor(t, canceled.Load, time.Second)
+	// Enqueue after close drops instead of panicking.
+	job := newQueueJob("a", 9)
+	q.enqueue(job)
+	if n := job.runner.status().ReportsDropped; n != 1 {
+		t.Errorf("ReportsDropped = %d, want 1", n)
+	}
+}
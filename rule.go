@@ -0,0 +1,177 @@
+package autopprof
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rule fires collections on a condition over the latest values of
+// several metrics, e.g. cpu >= 0.7 AND goroutine >= 10000, where each
+// metric's own threshold alone would be too noisy. A rule fires when
+// its condition holds on an evaluation, then again every
+// MinConsecutiveOverThreshold evaluations while it keeps holding, like
+// a metric's breach.
+type Rule struct {
+	// Name identifies the rule; it is the TriggeredBy of its reports.
+	Name string
+	// When is the condition, built with Above, Below, All and Any.
+	When Condition
+	// Collect are the metrics, built-in or custom, collected and
+	// reported when the rule fires. Empty means the metrics When
+	// refers to. Names that are not registered are skipped.
+	Collect []string
+	// Interval is how often the rule is evaluated. Defaults to
+	// Option.WatchInterval when left zero.
+	Interval time.Duration
+	// Cooldown is the minimum wall-clock time between two fires. Zero
+	// disables it.
+	Cooldown time.Duration
+}
+
+func (r Rule) validate() bool {
+	if r.Name == "" || r.When == nil || !r.When.valid() || r.Interval < 0 || r.Cooldown < 0 {
+		return false
+	}
+	for _, name := range r.Collect {
+		if name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// collect returns the names of the metrics the rule collects.
+func (r Rule) collect() []string {
+	if len(r.Collect) > 0 {
+		return r.Collect
+	}
+	return r.When.metricNames(nil)
+}
+
+// Condition is a condition over the latest values of metrics, built
+// with Above, Below, All and Any. Every comparison on a metric that
+// has no fresh value, one queried within the last three of its watch
+// intervals, is false.
+type Condition interface {
+	// String describes the condition, e.g.
+	// "cpu >= 0.7 AND goroutine >= 10000".
+	String() string
+
+	eval(value func(metric string) (float64, bool)) bool
+	metricNames(names []string) []string
+	valid() bool
+}
+
+// Above holds while the latest value of metric is at or above
+// threshold.
+func Above(metric string, threshold float64) Condition {
+	return comparison{metric: metric, threshold: threshold, direction: DirectionAbove}
+}
+
+// Below holds while the latest value of metric is at or below
+// threshold.
+func Below(metric string, threshold float64) Condition {
+	return comparison{metric: metric, threshold: threshold, direction: DirectionBelow}
+}
+
+// All holds while every one of conds holds (AND).
+func All(conds ...Condition) Condition {
+	return junction{all: true, conds: conds}
+}
+
+// Any holds while at least one of conds holds (OR).
+func Any(conds ...Condition) Condition {
+	return junction{conds: conds}
+}
+
+type comparison struct {
+	metric    string
+	threshold float64
+	direction Direction
+}
+
+func (c comparison) String() string {
+	op := ">="
+	if c.direction == DirectionBelow {
+		op = "<="
+	}
+	return fmt.Sprintf("%s %s %g", c.metric, op, c.threshold)
+}
+
+func (c comparison) eval(value func(string) (float64, bool)) bool {
+	v, ok := value(c.metric)
+	if !ok {
+		return false
+	}
+	if c.direction == DirectionBelow {
+		return v <= c.threshold
+	}
+	return v >= c.threshold
+}
+
+func (c comparison) metricNames(names []string) []string {
+	if containsString(names, c.metric) {
+		return names
+	}
+	return append(names, c.metric)
+}
+
+func (c comparison) valid() bool { return c.metric != "" }
+
+type junction struct {
+	all   bool
+	conds []Condition
+}
+
+func (j junction) String() string {
+	op := " OR "
+	if j.all {
+		op = " AND "
+	}
+	parts := make([]string, len(j.conds))
+	for i, c := range j.conds {
+		parts[i] = c.String()
+		if _, nested := c.(junction); nested {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, op)
+}
+
+func (j junction) eval(value func(string) (float64, bool)) bool {
+	for _, c := range j.conds {
+		if c.eval(value) != j.all {
+			return !j.all
+		}
+	}
+	return j.all
+}
+
+func (j junction) metricNames(names []string) []string {
+	for _, c := range j.conds {
+		names = c.metricNames(names)
+	}
+	return names
+}
+
+func (j junction) valid() bool {
+	if len(j.conds) == 0 {
+		return false
+	}
+	for _, c := range j.conds {
+		if c == nil || !c.valid() {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daangn/autopprof/v2/report"
+	"github.com/golang/mock/gomock"
+)
+
+func TestCondition(t *testing.T) {
+	values := map[string]float64{"cpu": 0.8, "goroutine": 500, "db_pool": 0.5}
+	value := func(name string) (float64, bool) {
+		v, ok := values[name]
+		return v, ok
+	}
+	testCases := []struct {
+		cond      Condition
+		wantStr   string
+		wantEval  bool
+		wantNames []string
+	}{
+		{Above("cpu", 0.7), "cpu >= 0.7", true, []string{"cpu"}},
+		{Below("db_pool", 0.2), "db_pool <= 0.2", false, []string{"db_pool"}},
+		{All(Above("cpu", 0.7), Above("goroutine", 10000)), "cpu >= 0.7 AND goroutine >= 10000", false, []string{"cpu", "goroutine"}},
+		{Any(Above("mem", 0.8), Above("cpu", 0.7)), "mem >= 0.8 OR cpu >= 0.7", true, []string{"mem", "cpu"}},
+		{
+			All(Above("cpu", 0.7), Any(Above("goroutine", 100), Below("db_pool", 0.1)), Above("cpu", 0.5)),
+			"cpu >= 0.7 AND (goroutine >= 100 OR db_pool <= 0.1) AND cpu >= 0.5", true,
+			[]string{"cpu", "goroutine", "db_pool"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.wantStr, func(t *testing.T) {
+			if got := tc.cond.String(); got != tc.wantStr {
+				t.Errorf("String() = %q", got)
+			}
+			if got := tc.cond.eval(value); got != tc.wantEval {
+				t.Errorf("eval() = %v, want %v", got, tc.wantEval)
+			}
+			names := tc.cond.metricNames(nil)
+			if len(names) != len(tc.wantNames) {
+				t.Fatalf("metricNames() = %v, want %v", names, tc.wantNames)
+			}
+			for i := range names {
+				if names[i] != tc.wantNames[i] {
+					t.Errorf("metricNames() = %v, want %v", names, tc.wantNames)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchRule(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		infos []report.ReportInfo
+	)
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, _ io.Reader, info report.ReportInfo) error {
+			mu.Lock()
+			infos = append(infos, info)
+			mu.Unlock()
+			return nil
+		})
+	ap := newTestAp(t, mockReporter)
+	ap.minConsecutiveOverThreshold = 1000
+	// Neither metric breaches its own threshold.
+	for name, value := range map[string]float64{"a": 5, "b": 7, "c": 1} {
+		value := value
+		fm := &fakeMetric{nameVal: name, thresholdVal: 100, intervalVal: 5 * time.Millisecond,
+			queryFn: func() (float64, error) { return value, nil }, collectFn: byteCollect}
+		if err := ap.registerMetric(fm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	rules := []Rule{
+		{Name: "both", When: All(Above("a", 5), Above("b", 6)), Interval: 5 * time.Millisecond},
+		{Name: "never", When: Any(Below("a", 1), Above("missing", 0)), Collect: []string{"c"}, Interval: 5 * time.Millisecond},
+	}
+	for _, rule := range rules {
+		ap.wg.Add(1)
+		go ap.watchRule(rule)
+	}
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(infos) >= 2
+	}, 2*time.Second)
+	time.Sleep(30 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(infos) != 2 {
+		t.Fatalf("reported %d times, want 2 (a and b once): %+v", len(infos), infos)
+	}
+	for i, name := range []string{"a", "b"} {
+		info := infos[i]
+		if info.MetricName != name || info.Trigger != report.TriggerRule || info.TriggeredBy != "both" ||
+			info.Condition != "a >= 5 AND b >= 6" || info.IsCascade || info.IncidentID != infos[0].IncidentID {
+			t.Errorf("unexpected %s report: %+v", name, info)
+		}
+	}
+	if want := ":rotating_light:[a] value=5.00, rule both: a >= 5 AND b >= 6"; infos[0].Comment != want {
+		t.Errorf("Comment = %q, want %q", infos[0].Comment, want)
+	}
+}
@@ -0,0 +1,91 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"time"
+
+	"github.com/daangn/autopprof/v2/report"
+)
+
+// ruleStaleIntervals is how many of its watch intervals a metric's
+// latest value stays usable by the rules.
+const ruleStaleIntervals = 3
+
+// watchRule evaluates rule every Interval until Stop, firing it like
+// watchMetric fires a breach: on the first evaluation that holds, then
+// every minConsecutiveOverThreshold evaluations while it keeps
+// holding, outside the rule's cooldown.
+func (ap *autoPprof) watchRule(rule Rule) {
+	defer ap.wg.Done()
+	ticker := time.NewTicker(rule.Interval)
+	defer ticker.Stop()
+
+	var (
+		cnt        int
+		lastFireAt time.Time
+	)
+	for {
+		select {
+		case <-ticker.C:
+			if !rule.When.eval(ap.latestValue) {
+				cnt = 0
+				continue
+			}
+			if cnt == 0 {
+				now := time.Now()
+				if !lastFireAt.IsZero() && now.Sub(lastFireAt) < rule.Cooldown {
+					continue
+				}
+				lastFireAt = now
+				ap.fireRule(rule)
+			}
+			cnt++
+			if cnt >= ap.minConsecutiveOverThreshold {
+				cnt = 0
+			}
+		case <-ap.stopC:
+			return
+		}
+	}
+}
+
+// latestValue returns the fresh latest value of the named metric.
+func (ap *autoPprof) latestValue(name string) (float64, bool) {
+	ap.mu.Lock()
+	runner, ok := ap.runners[name]
+	ap.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	return runner.latest(ruleStaleIntervals, time.Now())
+}
+
+// fireRule collects and reports the metrics of rule as a new
+// incident.
+func (ap *autoPprof) fireRule(rule Rule) {
+	condition := rule.When.String()
+	ap.logger.Info("autopprof: rule fired",
+		"rule", rule.Name,
+		"condition", condition,
+	)
+	var runners []*metricRunner
+	ap.mu.Lock()
+	for _, name := range rule.collect() {
+		if r, ok := ap.runners[name]; ok {
+			runners = append(runners, r)
+		}
+	}
+	ap.mu.Unlock()
+	targets := runners[:0]
+	for _, r := range runners {
+		if !r.config().Disabled {
+			targets = append(targets, r)
+		}
+	}
+
+	inc := newIncident(rule.Name)
+	inc.trigger, inc.condition = report.TriggerRule, condition
+	ap.cascadeFrom(inc, targets)
+}
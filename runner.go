@@ -0,0 +1,407 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type metricRunner struct {
+	metric  Metric
+	name    string
+	builtin bool
+	// groups are the cascade groups the metric opted into via
+	// CascadeMetric.
+	groups []string
+
+	// callMu serializes Query and Collect. The watcher, the built-in
+	// cascade and Capture may all call them, and Metric implementations
+	// are promised they need no internal synchronization.
+	callMu sync.Mutex
+
+	// mu guards cfg, failure and state. The watch loop snapshots cfg
+	// once per tick, so an Update applies atomically between ticks.
+	mu  sync.Mutex
+	cfg MetricConfig
+	// failure is the last Query error of a watcher that exhausted its
+	// error budget and exited; nil while the watcher is alive.
+	failure error
+	state   runnerState
+	// removed is set by Unregister, under callMu too, so no Query or
+	// Collect starts afterwards. inflight counts the callers other
+	// than the watcher, cascades and Capture, that hold the runner;
+	// Unregister waits for them.
+	removed  bool
+	inflight sync.WaitGroup
+
+	// reconfC wakes the watcher after Update so a new interval takes
+	// effect without waiting for the old ticker.
+	reconfC chan struct{}
+	// stopC is closed by Unregister to stop only this runner's
+	// watcher; doneC is closed when the watcher goroutine returns.
+	stopC chan struct{}
+	doneC chan struct{}
+}
+
+// runnerState is the live state surfaced by Status.
+type runnerState struct {
+	lastValue       float64
+	lastQueryAt     time.Time
+	lastErr         error
+	lastErrAt       time.Time
+	consecutiveOver int
+	lastReportAt    time.Time
+	lastReportErr   error
+	reportsFired    int
+	reportsDropped  int
+}
+
+// breachTracker evaluates a Sustain condition over the samples seen
+// by one watcher. It is owned by the watch loop goroutine.
+type breachTracker struct {
+	sustain Sustain
+	// since is the time of the first sample of the current unbroken
+	// run of breaching samples; zero outside a run.
+	since time.Time
+	// samples is a ring of the last sustain.window() samples; over
+	// counts the breaching ones.
+	samples []bool
+	next    int
+	over    int
+}
+
+// observe records one sample and reports whether the breach is
+// sustained. A changed Sustain (via Update) starts over.
+func (t *breachTracker) observe(sustain Sustain, breach bool, now time.Time) bool {
+	if sustain != t.sustain {
+		t.sustain = sustain
+		t.reset()
+	}
+	if !breach {
+		t.since = time.Time{}
+	} else if t.since.IsZero() {
+		t.since = now
+	}
+	if len(t.samples) > 0 {
+		if t.samples[t.next] {
+			t.over--
+		}
+		t.samples[t.next] = breach
+		if breach {
+			t.over++
+		}
+		t.next = (t.next + 1) % len(t.samples)
+	}
+	return breach &&
+		now.Sub(t.since) >= sustain.For &&
+		t.over >= sustain.Samples
+}
+
+func (t *breachTracker) reset() {
+	t.since = time.Time{}
+	t.samples = make([]bool, t.sustain.window())
+	t.next, t.over = 0, 0
+}
+
+// rateTracker keeps the samples of the last Rate.Over seen by one
+// watcher to measure how fast the value changes. It is owned by the
+// watch loop goroutine.
+type rateTracker struct {
+	rate    Rate
+	samples []rateSample
+}
+
+type rateSample struct {
+	at    time.Time
+	value float64
+}
+
+// observe records one sample and returns the change over the window:
+// the rise from its lowest sample, or the fall from its highest for
+// DirectionBelow. breach is true once the change exceeds Rate.Delta.
+// A changed Rate (via Update) starts over.
+func (t *rateTracker) observe(
+	rate Rate, direction Direction, value float64, now time.Time,
+) (change float64, breach bool) {
+	if rate != t.rate {
+		t.rate = rate
+		t.reset()
+	}
+	if !rate.enabled() {
+		return 0, false
+	}
+	cutoff := now.Add(-rate.Over)
+	expired := 0
+	for expired < len(t.samples) && t.samples[expired].at.Before(cutoff) {
+		expired++
+	}
+	t.samples = append(t.samples[expired:], rateSample{at: now, value: value})
+	for _, s := range t.samples {
+		c := value - s.value
+		if direction == DirectionBelow {
+			c = -c
+		}
+		if c > change {
+			change = c
+		}
+	}
+	return change, change > rate.Delta
+}
+
+func (t *rateTracker) reset() {
+	t.samples = nil
+}
+
+// anomalyTracker keeps the EWMA baseline of the values seen by one
+// watcher. It is owned by the watch loop goroutine.
+type anomalyTracker struct {
+	anomaly  Anomaly
+	samples  int
+	mean     float64
+	variance float64
+}
+
+// observe compares value to the baseline, then folds it in. It
+// returns the baseline mean and how many standard deviations value is
+// above it, or below it for DirectionBelow. breach is true past
+// Anomaly.K once warmed up and past the Floor. A changed Anomaly (via
+// Update) starts over.
+func (t *anomalyTracker) observe(
+	anomaly Anomaly, direction Direction, value float64,
+) (baseline, deviation float64, breach bool) {
+	if anomaly != t.anomaly {
+		t.anomaly = anomaly
+		t.reset()
+	}
+	if !anomaly.enabled() {
+		return 0, 0, false
+	}
+	anomaly = anomaly.withDefaults()
+
+	baseline = t.mean
+	if t.samples > 0 {
+		sd := math.Max(math.Sqrt(t.variance), math.Abs(t.mean)/100)
+		diff := value - t.mean
+		if direction == DirectionBelow {
+			diff = -diff
+		}
+		if sd > 0 {
+			deviation = diff / sd
+		}
+	}
+	floored := value >= anomaly.Floor
+	if direction == DirectionBelow {
+		floored = anomaly.Floor == 0 || value <= anomaly.Floor
+	}
+	breach = t.samples >= anomaly.WarmUp && deviation > anomaly.K && floored
+
+	if t.samples == 0 {
+		t.mean = value
+	} else {
+		diff := value - t.mean
+		incr := anomaly.Alpha * diff
+		t.mean += incr
+		t.variance = (1 - anomaly.Alpha) * (t.variance + diff*incr)
+	}
+	t.samples++
+	return baseline, deviation, breach
+}
+
+func (t *anomalyTracker) reset() {
+	t.samples, t.mean, t.variance = 0, 0, 0
+}
+
+// newRunner caches Metric's meta values so the watch loop uses a
+// stable name/threshold/interval even if the implementation mutates
+// them later. cfg must already be resolved against instance defaults.
+func newRunner(m Metric, cfg MetricConfig) *metricRunner {
+	r := &metricRunner{
+		metric:  m,
+		name:    m.Name(),
+		cfg:     cfg,
+		reconfC: make(chan struct{}, 1),
+		stopC:   make(chan struct{}),
+		doneC:   make(chan struct{}),
+	}
+	if c, ok := m.(CascadeMetric); ok {
+		r.groups = append([]string(nil), c.CascadeGroups()...)
+	}
+	return r
+}
+
+// config returns a snapshot of the runner's live configuration.
+func (r *metricRunner) config() MetricConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// update swaps the whole configuration and nudges the watcher.
+func (r *metricRunner) update(cfg MetricConfig) {
+	r.mu.Lock()
+	r.cfg = cfg
+	r.mu.Unlock()
+	select {
+	case r.reconfC <- struct{}{}:
+	default:
+	}
+}
+
+// fail records that the watcher gave up because of err.
+func (r *metricRunner) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failure = err
+}
+
+func (r *metricRunner) failed() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failure
+}
+
+// acquire holds the runner for a caller other than its watcher until
+// release. It returns false once the runner is removed.
+func (r *metricRunner) acquire() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.removed {
+		return false
+	}
+	r.inflight.Add(1)
+	return true
+}
+
+func (r *metricRunner) release() {
+	r.inflight.Done()
+}
+
+// remove makes every later query and collect fail with
+// ErrMetricNotFound, waits for a running Query or Collect via callMu,
+// then for the callers holding the runner.
+func (r *metricRunner) remove() {
+	r.callMu.Lock()
+	r.mu.Lock()
+	r.removed = true
+	r.mu.Unlock()
+	r.callMu.Unlock()
+	r.inflight.Wait()
+}
+
+func (r *metricRunner) query() (float64, error) {
+	r.callMu.Lock()
+	if r.removed {
+		r.callMu.Unlock()
+		return 0, ErrMetricNotFound
+	}
+	value, err := r.metric.Query()
+	r.callMu.Unlock()
+
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.state.lastErr, r.state.lastErrAt = err, now
+		return value, err
+	}
+	r.state.lastValue, r.state.lastQueryAt = value, now
+	return value, nil
+}
+
+func (r *metricRunner) collect(value float64) (CollectResult, error) {
+	r.callMu.Lock()
+	defer r.callMu.Unlock()
+	if r.removed {
+		return CollectResult{}, ErrMetricNotFound
+	}
+	return r.metric.Collect(value)
+}
+
+// recordError keeps err as the runner's last error.
+func (r *metricRunner) recordError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.lastErr, r.state.lastErrAt = err, time.Now()
+}
+
+// recordReport keeps the outcome of a Reporter.Report call.
+func (r *metricRunner) recordReport(err error) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.lastReportAt, r.state.lastReportErr = now, err
+	r.state.reportsFired++
+	if err != nil {
+		r.state.lastErr, r.state.lastErrAt = err, now
+	}
+}
+
+// latest returns the latest queried value, if it is no older than n
+// watch intervals and the watcher isn't paused.
+func (r *metricRunner) latest(n int, now time.Time) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cfg.Disabled || r.state.lastQueryAt.IsZero() ||
+		now.Sub(r.state.lastQueryAt) > time.Duration(n)*r.cfg.Interval {
+		return 0, false
+	}
+	return r.state.lastValue, true
+}
+
+// snapshotState returns a copy of the live state.
+func (r *metricRunner) snapshotState() runnerState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// recordDrop counts a report the report queue dropped.
+func (r *metricRunner) recordDrop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.reportsDropped++
+}
+
+func (r *metricRunner) setConsecutiveOver(cnt int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.consecutiveOver = cnt
+}
+
+func (r *metricRunner) status() MetricStatus {
+	watching := true
+	select {
+	case <-r.doneC:
+		watching = false
+	default:
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return MetricStatus{
+		Name:            r.name,
+		Threshold:       r.cfg.Threshold,
+		Direction:       r.cfg.Direction,
+		Interval:        r.cfg.Interval,
+		Cooldown:        r.cfg.Cooldown,
+		ReArmThreshold:  r.cfg.reArmThreshold(),
+		Sustain:         r.cfg.Sustain,
+		Rate:            r.cfg.Rate,
+		Anomaly:         r.cfg.Anomaly,
+		BuiltIn:         r.builtin,
+		Disabled:        r.cfg.Disabled,
+		Watching:        watching,
+		LastValue:       r.state.lastValue,
+		LastQueryTime:   r.state.lastQueryAt,
+		LastError:       r.state.lastErr,
+		LastErrorTime:   r.state.lastErrAt,
+		ConsecutiveOver: r.state.consecutiveOver,
+		LastReportTime:  r.state.lastReportAt,
+		LastReportError: r.state.lastReportErr,
+		ReportsFired:    r.state.reportsFired,
+		ReportsDropped:  r.state.reportsDropped,
+	}
+}
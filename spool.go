@@ -0,0 +1,233 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daangn/autopprof/v2/report"
+)
+
+const (
+	spoolDataExt = ".data"
+	spoolMetaExt = ".json"
+	spoolTmpExt  = ".tmp"
+
+	// spoolStaleAge is the age past which the leftovers of an
+	// interrupted write are removed.
+	spoolStaleAge = time.Minute
+)
+
+// spool keeps the payloads the Reporter failed to deliver in a local
+// directory. An entry is a payload file plus a metadata file written
+// after it, so an entry without metadata is an interrupted write.
+type spool struct {
+	opt SpoolOption
+
+	// mu guards the directory listing against concurrent save and
+	// delete; replayMu serializes replays so no entry is delivered
+	// twice.
+	mu       sync.Mutex
+	replayMu sync.Mutex
+	seq      uint64
+}
+
+// spoolMeta is the metadata file of an entry.
+type spoolMeta struct {
+	Info      report.ReportInfo `json:"info"`
+	SpooledAt time.Time         `json:"spooled_at"`
+}
+
+// spoolEntry is one entry found in the directory.
+type spoolEntry struct {
+	base string // path without extension
+	meta spoolMeta
+	size int64
+}
+
+func newSpool(opt SpoolOption) (*spool, error) {
+	if err := os.MkdirAll(opt.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("autopprof: failed to create the spool directory: %w", err)
+	}
+	return &spool{opt: opt}, nil
+}
+
+// save writes payload and info as a new entry, then evicts the oldest
+// entries beyond MaxBytes.
+func (s *spool) save(payload []byte, info report.ReportInfo) error {
+	if int64(len(payload)) > s.opt.MaxBytes {
+		return ErrSpoolFull
+	}
+	meta, err := json.Marshal(spoolMeta{Info: info, SpooledAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	base := filepath.Join(s.opt.Dir, fmt.Sprintf(
+		"%020d-%06d-%s", time.Now().UnixNano(), s.seq%1000000, spoolSafeName(info.MetricName),
+	))
+	if err := writeFileAtomic(base+spoolDataExt, payload); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(base+spoolMetaExt, meta); err != nil {
+		os.Remove(base + spoolDataExt)
+		return err
+	}
+	return s.evictLocked()
+}
+
+// evictLocked drops expired entries and, oldest first, the entries
+// beyond MaxBytes.
+func (s *spool) evictLocked() error {
+	entries, err := s.listLocked()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	for _, e := range entries {
+		if total <= s.opt.MaxBytes {
+			break
+		}
+		removeSpoolEntry(e.base)
+		total -= e.size
+	}
+	return nil
+}
+
+// listLocked returns the live entries, oldest first, removing the
+// expired and incomplete ones, and the temporary files of interrupted
+// writes, on the way.
+func (s *spool) listLocked() ([]spoolEntry, error) {
+	now := time.Now()
+	tmps, err := filepath.Glob(filepath.Join(s.opt.Dir, "*"+spoolTmpExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range tmps {
+		if stat, err := os.Stat(name); err == nil && now.Sub(stat.ModTime()) > spoolStaleAge {
+			os.Remove(name)
+		}
+	}
+
+	names, err := filepath.Glob(filepath.Join(s.opt.Dir, "*"+spoolDataExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	entries := make([]spoolEntry, 0, len(names))
+	for _, name := range names {
+		base := strings.TrimSuffix(name, spoolDataExt)
+		stat, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(base + spoolMetaExt)
+		if os.IsNotExist(err) {
+			// Interrupted write: remove it once it's clearly stale.
+			if now.Sub(stat.ModTime()) > spoolStaleAge {
+				removeSpoolEntry(base)
+			}
+			continue
+		}
+		var meta spoolMeta
+		if err != nil || json.Unmarshal(b, &meta) != nil ||
+			now.Sub(meta.SpooledAt) > s.opt.MaxAge {
+			removeSpoolEntry(base)
+			continue
+		}
+		entries = append(entries, spoolEntry{base: base, meta: meta, size: stat.Size()})
+	}
+	return entries, nil
+}
+
+// replay delivers the entries oldest first through deliver, deleting
+// each one delivered. It stops at the first failure, since the
+// Reporter is likely still down, and returns the number delivered.
+func (s *spool) replay(
+	ctx context.Context,
+	deliver func(context.Context, io.Reader, report.ReportInfo) error,
+) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	entries, err := s.listLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+		payload, err := os.ReadFile(e.base + spoolDataExt)
+		if err != nil {
+			// Evicted meanwhile.
+			continue
+		}
+		if err := deliver(ctx, bytes.NewReader(payload), e.meta.Info); err != nil {
+			return delivered, err
+		}
+		s.mu.Lock()
+		removeSpoolEntry(e.base)
+		s.mu.Unlock()
+		delivered++
+	}
+	return delivered, nil
+}
+
+func removeSpoolEntry(base string) {
+	os.Remove(base + spoolMetaExt)
+	os.Remove(base + spoolDataExt)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into
+// place, so readers never see a partial file.
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + spoolTmpExt
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// spoolSafeName keeps metric names usable as a file name segment.
+func spoolSafeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// rewindable returns r as a reader that can be read again from its
+// current position: a Seeker as is, anything else buffered.
+func rewindable(r io.Reader) (io.ReadSeeker, int64, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		return rs, start, err
+	}
+	b, err := io.ReadAll(r)
+	return bytes.NewReader(b), 0, err
+}
@@ -0,0 +1,225 @@
+//go:build linux
+// +build linux
+
+package autopprof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daangn/autopprof/v2/report"
+	"github.com/golang/mock/gomock"
+)
+
+func newTestSpool(t *testing.T, opt SpoolOption) *spool {
+	t.Helper()
+	opt.Dir = t.TempDir()
+	sp, err := newSpool(opt.withDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+// spoolLen returns the number of complete entries in sp.
+func spoolLen(t *testing.T, sp *spool) int {
+	t.Helper()
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	entries, err := sp.listLocked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestSpool_replay(t *testing.T) {
+	sp := newTestSpool(t, SpoolOption{})
+	for _, name := range []string{"cpu", "db/pool"} {
+		if err := sp.save([]byte(name+"-payload"), report.ReportInfo{MetricName: name, Value: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errDown := errors.New("down")
+	n, err := sp.replay(context.Background(), func(context.Context, io.Reader, report.ReportInfo) error {
+		return errDown
+	})
+	if n != 0 || !errors.Is(err, errDown) || spoolLen(t, sp) != 2 {
+		t.Fatalf("failed replay: n=%d err=%v entries=%d", n, err, spoolLen(t, sp))
+	}
+
+	var got []string
+	n, err = sp.replay(context.Background(), func(_ context.Context, r io.Reader, info report.ReportInfo) error {
+		b, _ := io.ReadAll(r)
+		if string(b) != info.MetricName+"-payload" {
+			t.Errorf("%s: payload %q", info.MetricName, b)
+		}
+		got = append(got, info.MetricName)
+		return nil
+	})
+	if n != 2 || err != nil {
+		t.Fatalf("replay: n=%d err=%v", n, err)
+	}
+	if len(got) != 2 || got[0] != "cpu" || got[1] != "db/pool" {
+		t.Errorf("replayed %v, want oldest first", got)
+	}
+	if files, _ := os.ReadDir(sp.opt.Dir); len(files) != 0 {
+		t.Errorf("spool directory not emptied: %d files left", len(files))
+	}
+}
+
+func TestSpool_limits(t *testing.T) {
+	t.Run("max bytes evicts the oldest", func(t *testing.T) {
+		sp := newTestSpool(t, SpoolOption{MaxBytes: 10})
+		for _, v := range []float64{1, 2} {
+			if err := sp.save([]byte("123456"), report.ReportInfo{Value: v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sp.mu.Lock()
+		entries, _ := sp.listLocked()
+		sp.mu.Unlock()
+		if len(entries) != 1 || entries[0].meta.Info.Value != 2 {
+			t.Errorf("unexpected entries after eviction: %+v", entries)
+		}
+		if err := sp.save(make([]byte, 11), report.ReportInfo{}); !errors.Is(err, ErrSpoolFull) {
+			t.Errorf("oversized save = %v, want ErrSpoolFull", err)
+		}
+	})
+	t.Run("max age expires", func(t *testing.T) {
+		sp := newTestSpool(t, SpoolOption{MaxAge: time.Millisecond})
+		if err := sp.save([]byte("x"), report.ReportInfo{}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if n := spoolLen(t, sp); n != 0 {
+			t.Errorf("expired entries kept: %d", n)
+		}
+	})
+	t.Run("incomplete entries are skipped", func(t *testing.T) {
+		sp := newTestSpool(t, SpoolOption{})
+		if err := os.WriteFile(filepath.Join(sp.opt.Dir, "1-orphan"+spoolDataExt), []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if n := spoolLen(t, sp); n != 0 {
+			t.Errorf("incomplete entry listed: %d", n)
+		}
+	})
+	t.Run("stale temporary files are swept", func(t *testing.T) {
+		sp := newTestSpool(t, SpoolOption{})
+		stale := filepath.Join(sp.opt.Dir, "1-crashed"+spoolDataExt+spoolTmpExt)
+		fresh := filepath.Join(sp.opt.Dir, "2-writing"+spoolMetaExt+spoolTmpExt)
+		for _, name := range []string{stale, fresh} {
+			if err := os.WriteFile(name, []byte("x"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		old := time.Now().Add(-2 * spoolStaleAge)
+		if err := os.Chtimes(stale, old, old); err != nil {
+			t.Fatal(err)
+		}
+		if n := spoolLen(t, sp); n != 0 {
+			t.Errorf("temporary file listed: %d", n)
+		}
+		if _, err := os.Stat(stale); !os.IsNotExist(err) {
+			t.Errorf("stale temporary file kept: %v", err)
+		}
+		if _, err := os.Stat(fresh); err != nil {
+			t.Errorf("fresh temporary file removed: %v", err)
+		}
+	})
+}
+
+func TestSpool_failedReportIsReplayed(t *testing.T) {
+	var (
+		down     atomic.Bool
+		mu       sync.Mutex
+		received []string
+	)
+	down.Store(true)
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(_ context.Context, r io.Reader, info report.ReportInfo) error {
+			b, _ := io.ReadAll(r)
+			if down.Load() {
+				return errors.New("egress lost")
+			}
+			mu.Lock()
+			received = append(received, info.Filename+":"+string(b))
+			mu.Unlock()
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.logger = &recordLogger{}
+	ap.spool = newTestSpool(t, SpoolOption{})
+	fm := &fakeMetric{nameVal: "pool", thresholdVal: 1, intervalVal: time.Hour,
+		queryFn: func() (float64, error) { return 0, nil },
+		collectFn: func(float64) (CollectResult, error) {
+			// Not seekable: deliver must buffer it to spool it.
+			return CollectResult{Reader: io.MultiReader(bytes.NewReader([]byte("dump"))), Filename: "pool.dump"}, nil
+		}}
+	if err := ap.registerMetric(fm); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ap.stop() })
+
+	if _, err := ap.capture(context.Background(), "pool"); err == nil {
+		t.Fatal("capture succeeded with the reporter down")
+	}
+	if n := spoolLen(t, ap.spool); n != 1 {
+		t.Fatalf("spooled %d entries, want 1", n)
+	}
+
+	down.Store(false)
+	n, err := ap.replaySpool(context.Background())
+	if n != 1 || err != nil {
+		t.Fatalf("replaySpool() = %d, %v", n, err)
+	}
+	if len(received) != 1 || received[0] != "pool.dump:dump" {
+		t.Errorf("received %v", received)
+	}
+}
+
+func TestSpool_backgroundRedelivery(t *testing.T) {
+	var delivered atomic.Int32
+	mockReporter := report.NewMockReporter(gomock.NewController(t))
+	mockReporter.EXPECT().Report(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().
+		DoAndReturn(func(context.Context, io.Reader, report.ReportInfo) error {
+			delivered.Add(1)
+			return nil
+		})
+
+	ap := newTestAp(t, mockReporter)
+	ap.logger = &recordLogger{}
+	ap.spool = newTestSpool(t, SpoolOption{RetryInterval: 5 * time.Millisecond})
+	if err := ap.spool.save([]byte("x"), report.ReportInfo{MetricName: "cpu"}); err != nil {
+		t.Fatal(err)
+	}
+	ap.wg.Add(1)
+	go ap.redeliverSpool()
+	t.Cleanup(func() { ap.stop() })
+
+	waitFor(t, func() bool { return delivered.Load() == 1 }, time.Second)
+	if n := spoolLen(t, ap.spool); n != 0 {
+		t.Errorf("%d entries left after redelivery", n)
+	}
+}
+
+func TestReplaySpool_disabled(t *testing.T) {
+	ap := newTestAp(t, nil)
+	if _, err := ap.replaySpool(context.Background()); !errors.Is(err, ErrSpoolDisabled) {
+		t.Errorf("replaySpool() = %v, want ErrSpoolDisabled", err)
+	}
+}
@@ -0,0 +1,55 @@
+package autopprof
+
+import "time"
+
+// MetricStatus is a point-in-time view of one registered metric: its
+// configuration plus what its watcher has seen so far.
+type MetricStatus struct {
+	Name      string
+	Threshold float64
+	Direction Direction
+	Interval  time.Duration
+	// Cooldown and ReArmThreshold are the effective cooldown and
+	// re-arm level.
+	Cooldown       time.Duration
+	ReArmThreshold float64
+	// Sustain is the sustained-breach condition.
+	Sustain Sustain
+	// Rate is the rate-of-change trigger.
+	Rate Rate
+	// Anomaly is the adaptive-baseline trigger.
+	Anomaly Anomaly
+	// BuiltIn is true for cpu, mem and goroutine.
+	BuiltIn bool
+	// Cascade is true if the metric takes part in the cascade: it is
+	// enabled and belongs to at least one cascade group, listed in
+	// CascadeGroups.
+	Cascade       bool
+	CascadeGroups []string
+	// Disabled is true while the watcher is paused via Update or
+	// Reconfigure.
+	Disabled bool
+	// Watching is false once the watcher goroutine has exited, e.g.
+	// after exhausting the QueryErrorPolicy budget.
+	Watching bool
+
+	// LastValue and LastQueryTime describe the latest successful Query.
+	LastValue     float64
+	LastQueryTime time.Time
+	// LastError is the most recent query, collect or report error, at
+	// LastErrorTime. It is not cleared by later successes.
+	LastError     error
+	LastErrorTime time.Time
+	// ConsecutiveOver is the debounce counter: breaching ticks since
+	// the last fire, reset once the value crosses ReArmThreshold.
+	// Ticks between the two thresholds keep it.
+	ConsecutiveOver int
+
+	// LastReportTime and LastReportError describe the latest
+	// Reporter.Report call; ReportsFired counts all of them.
+	LastReportTime  time.Time
+	LastReportError error
+	ReportsFired    int
+	// ReportsDropped counts the reports the ReportQueue dropped.
+	ReportsDropped int
+}
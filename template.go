@@ -0,0 +1,66 @@
+package autopprof
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is what Option.CommentTemplate and
+// Option.FilenameTemplate execute with.
+type TemplateData struct {
+	MetricName string
+	// Value and Threshold are raw: ratios between 0 and 1 for cpu and
+	// mem. The percent function formats them as percentages.
+	Value     float64
+	Threshold float64
+	// Direction, Trigger, Condition, Change, Baseline and Deviation
+	// are as in report.ReportInfo.
+	Direction string
+	Trigger   string
+	Condition string
+	Change    float64
+	Baseline  float64
+	Deviation float64
+	// BuiltIn is true for cpu, mem and goroutine.
+	BuiltIn  bool
+	App      string
+	Hostname string
+	// Time is when the payload was collected.
+	Time   time.Time
+	Labels map[string]string
+	Build  BuildInfo
+	// IncidentID, TriggeredBy and IsCascade are as in
+	// report.ReportInfo.
+	IncidentID  string
+	TriggeredBy string
+	IsCascade   bool
+}
+
+// templateFuncs are the functions available to the templates besides
+// the text/template built-ins.
+var templateFuncs = template.FuncMap{
+	// percent formats a ratio as a percentage: 0.853 -> "85.30".
+	"percent": func(v float64) string { return fmt.Sprintf("%.2f", v*100) },
+}
+
+// parseTemplate parses text under name; empty text yields nil.
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, name, err)
+	}
+	return t, nil
+}
+
+func executeTemplate(t *template.Template, data TemplateData) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}